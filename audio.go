@@ -0,0 +1,334 @@
+package gotcha
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/djangulo/go-espeak/wav"
+	gostorage "github.com/djangulo/go-storage"
+
+	"github.com/djangulo/gotcha/draw"
+)
+
+// NoiseType noise mixed into the challenge audio.
+type NoiseType uint8
+
+const (
+	// NoiseNone leaves the audio clean, the default.
+	NoiseNone NoiseType = iota
+	// NoiseWhite gaussian white noise.
+	NoiseWhite
+	// NoiseBabble overlapping, reversed copies of the speech itself, that
+	// sound like unintelligible voices talking over it.
+	NoiseBabble
+)
+
+// AudioOptions post-processing of the challenge audio, that makes it harder
+// to transcribe with speech to text, see WithAudio. The text is read in
+// segments, its words, or its characters with Spell, each of them varied on
+// its own.
+type AudioOptions struct {
+	// Noise mixed into the audio.
+	Noise NoiseType
+	// SNR signal to noise ratio of Noise, in dB, lower is noisier.
+	SNR float64
+	// Pitch and Speed largest random change of the pitch and of the speed
+	// of each segment, 0.2 for up to 20% higher or lower.
+	Pitch float64
+	Speed float64
+	// Voices voice variants the segments are read in, picked at random, see
+	// VoiceVariant. 0 or 1 for the default voice only.
+	Voices int
+	// MinPause and MaxPause range of the random pauses between segments.
+	MinPause time.Duration
+	MaxPause time.Duration
+	// Spell read Random challenges one character at a time.
+	Spell bool
+}
+
+// segmented whether the text is read one word at a time.
+func (o AudioOptions) segmented() bool {
+	return o.Pitch > 0 || o.Speed > 0 || o.Voices > 1 || o.MaxPause > 0
+}
+
+// speak reads q with the speaker of m, post-processed as set by m.audio.
+func (m *Manager) speak(ctx context.Context, src Source, lang, q string) ([]int16, int, error) {
+	o := m.audio
+	var rnd *rand.Rand
+	if r, ok := ctx.Value(draw.Rand).(*rand.Rand); ok {
+		rnd = r
+	} else {
+		rnd = rand.New(rand.NewSource(rand.Int63()))
+	}
+
+	segments := []string{q}
+	switch {
+	case o.Spell && src == Random:
+		segments = nil
+		for _, r := range q {
+			segments = append(segments, string(r))
+		}
+	case o.segmented():
+		segments = strings.Fields(q)
+	}
+
+	var (
+		out  []int16
+		rate int
+	)
+	for _, seg := range segments {
+		sctx := ctx
+		if o.Voices > 1 {
+			sctx = context.WithValue(ctx, VoiceVariant, rnd.Intn(o.Voices))
+		}
+		samples, r, err := m.speaker().Speak(sctx, seg, lang)
+		if err != nil {
+			return nil, 0, err
+		}
+		if len(samples) == 0 {
+			continue
+		}
+		if rate == 0 {
+			rate = r
+		} else if r != rate {
+			samples = resample(samples, float64(r)/float64(rate))
+		}
+		if o.Pitch > 0 || o.Speed > 0 {
+			samples = vary(
+				samples,
+				rate,
+				1+o.Pitch*(2*rnd.Float64()-1),
+				1+o.Speed*(2*rnd.Float64()-1),
+			)
+		}
+		if len(out) > 0 && o.MaxPause > 0 {
+			pause := o.MinPause
+			if o.MaxPause > o.MinPause {
+				pause += time.Duration(rnd.Int63n(int64(o.MaxPause - o.MinPause)))
+			}
+			out = append(out, make([]int16, int(pause.Seconds()*float64(rate)))...)
+		}
+		out = append(out, samples...)
+	}
+	if len(out) == 0 {
+		return nil, 0, nil
+	}
+	if o.Noise != NoiseNone {
+		out = mixNoise(out, o.Noise, o.SNR, rnd)
+	}
+	return out, rate, nil
+}
+
+// vary shifts the pitch of samples by pitch, and changes their speed by
+// speed, 1 leaves them as they are.
+func vary(samples []int16, sampleRate int, pitch, speed float64) []int16 {
+	if pitch <= 0 || speed <= 0 {
+		return samples
+	}
+	// resampling shifts the pitch and the length alike, stretching sets the
+	// length back, changed by speed
+	return stretch(resample(samples, pitch), sampleRate, pitch/speed)
+}
+
+// resample reads samples ratio times as fast, interpolating linearly.
+func resample(samples []int16, ratio float64) []int16 {
+	n := int(float64(len(samples)) / ratio)
+	out := make([]int16, n)
+	for i := range out {
+		x := float64(i) * ratio
+		j := int(x)
+		if j+1 >= len(samples) {
+			out[i] = samples[len(samples)-1]
+			continue
+		}
+		f := x - float64(j)
+		out[i] = int16(math.Round(float64(samples[j])*(1-f) + float64(samples[j+1])*f))
+	}
+	return out
+}
+
+// stretch makes samples factor times as long without changing their pitch,
+// overlapping and adding 30ms windows of them (WSOLA). Each window is taken
+// near its nominal position, where it best continues the previous one, so
+// that they add up in phase.
+func stretch(samples []int16, sampleRate int, factor float64) []int16 {
+	win := sampleRate * 30 / 1000
+	if win < 4 || len(samples) < 2*win || math.Abs(factor-1) < 0.001 {
+		return samples
+	}
+	hopOut := win / 2
+	hopIn := float64(hopOut) / factor
+	tolerance := win / 4
+	n := int(float64(len(samples)) * factor)
+	acc := make([]float64, n+win)
+	weight := make([]float64, n+win)
+	prev := 0
+	for k := 0; ; k++ {
+		in, out := int(float64(k)*hopIn), k*hopOut
+		if in+win > len(samples) || out >= n {
+			break
+		}
+		if k > 0 && prev+hopOut+hopOut <= len(samples) {
+			// the samples that followed the previous window
+			next := samples[prev+hopOut : prev+hopOut+hopOut]
+			best, bestCorr := in, math.Inf(-1)
+			for pos := in - tolerance; pos <= in+tolerance; pos++ {
+				if pos < 0 || pos+win > len(samples) {
+					continue
+				}
+				var corr float64
+				for i, v := range next {
+					corr += float64(v) * float64(samples[pos+i])
+				}
+				if corr > bestCorr {
+					best, bestCorr = pos, corr
+				}
+			}
+			in = best
+		}
+		for i := 0; i < win; i++ {
+			// hann window
+			w := 0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/float64(win-1))
+			acc[out+i] += w * float64(samples[in+i])
+			weight[out+i] += w
+		}
+		prev = in
+	}
+	res := make([]int16, n)
+	for i := range res {
+		if weight[i] > 1e-3 {
+			res[i] = int16(math.Round(acc[i] / weight[i]))
+		}
+	}
+	return res
+}
+
+// mixNoise adds noise to samples, snr dB quieter than the speech in them.
+// The result is scaled down if it would clip.
+func mixNoise(samples []int16, noise NoiseType, snr float64, rnd *rand.Rand) []int16 {
+	// power of the speech, leaving out the pauses
+	var power float64
+	var loud int
+	for _, s := range samples {
+		if s > 256 || s < -256 {
+			power += float64(s) * float64(s)
+			loud++
+		}
+	}
+	if loud == 0 {
+		return samples
+	}
+	power /= float64(loud)
+
+	n := make([]float64, len(samples))
+	switch noise {
+	case NoiseBabble:
+		for k := 0; k < 4; k++ {
+			off := rnd.Intn(len(samples))
+			for i := range n {
+				n[i] += float64(samples[(len(samples)-1-i+off)%len(samples)])
+			}
+		}
+	default:
+		for i := range n {
+			n[i] = rnd.NormFloat64()
+		}
+	}
+	var noisePower float64
+	for _, v := range n {
+		noisePower += v * v
+	}
+	noisePower /= float64(len(n))
+	if noisePower == 0 {
+		return samples
+	}
+	gain := math.Sqrt(power / math.Pow(10, snr/10) / noisePower)
+
+	mixed := make([]float64, len(samples))
+	peak := 0.0
+	for i, s := range samples {
+		mixed[i] = float64(s) + gain*n[i]
+		peak = math.Max(peak, math.Abs(mixed[i]))
+	}
+	scale := 1.0
+	if peak > math.MaxInt16 {
+		scale = math.MaxInt16 / peak
+	}
+	out := make([]int16, len(samples))
+	for i, v := range mixed {
+		out[i] = int16(math.Round(v * scale))
+	}
+	return out
+}
+
+// encoder returns the AudioEncoder of m, WAV by default.
+func (m *Manager) encoder() AudioEncoder {
+	if m.audioEncoder != nil {
+		return m.audioEncoder
+	}
+	return WAV
+}
+
+// checkEncoder returns an error if FileStorage would store the audio of m
+// with a Content-Type other than that of its encoder: the bucket drivers of
+// go-storage, S3 and DigitalOcean Spaces, set the Content-Type of the files
+// from their extension, and label those they do not know, as .flac, text/html.
+// The fs driver stores no Content-Type, the server in front of it sets one.
+func (m *Manager) checkEncoder() error {
+	if m.inline || m.FileStorage == nil {
+		return nil
+	}
+	if _, ok := m.FileStorage.(fileRoot); ok {
+		return nil
+	}
+	if _, ok := m.FileStorage.(ContentTypeAdder); ok {
+		return nil
+	}
+	enc := m.encoder()
+	if ct := gostorage.ResolveContentType("audio" + enc.Ext()); ct != enc.ContentType() {
+		return fmt.Errorf("gotcha: FileStorage would label %s audio as %s, use inline media or the fs driver", enc.Ext(), ct)
+	}
+	return nil
+}
+
+// contentType MIME type of the media file name.
+func (m *Manager) contentType(name string) string {
+	if enc := m.encoder(); path.Ext(name) == enc.Ext() {
+		return enc.ContentType()
+	}
+	return gostorage.ResolveContentType(name)
+}
+
+// AudioEncoder encodes the challenge audio, see WithAudioEncoder.
+type AudioEncoder interface {
+	// Encode writes samples, 16 bit mono at sampleRate, to w.
+	Encode(w io.Writer, samples []int16, sampleRate int) error
+	// Ext file extension of the encoded audio, ".wav".
+	Ext() string
+	// ContentType MIME type of the encoded audio, "audio/wav".
+	ContentType() string
+}
+
+var (
+	// WAV uncompressed PCM audio, the default.
+	WAV AudioEncoder = wavEncoder{}
+	// FLAC lossless audio, about half the size of WAV, that all major
+	// browsers play.
+	FLAC AudioEncoder = flacEncoder{}
+)
+
+type wavEncoder struct{}
+
+func (wavEncoder) Ext() string         { return ".wav" }
+func (wavEncoder) ContentType() string { return "audio/wav" }
+
+func (wavEncoder) Encode(w io.Writer, samples []int16, sampleRate int) error {
+	_, err := wav.NewWriter(w, int32(sampleRate)).WriteSamples(samples)
+	return err
+}
@@ -0,0 +1,152 @@
+package gotcha
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/djangulo/gotcha/draw"
+)
+
+// sine one second of a tone of freq Hz at 22050Hz.
+func sine(freq, amplitude float64) []int16 {
+	s := make([]int16, 22050)
+	for i := range s {
+		s[i] = int16(amplitude * math.Sin(2*math.Pi*freq*float64(i)/22050))
+	}
+	return s
+}
+
+// frequency estimates the frequency of samples at 22050Hz from their zero
+// crossings.
+func frequency(samples []int16) float64 {
+	var crossings int
+	for i := 1; i < len(samples); i++ {
+		if (samples[i-1] < 0) != (samples[i] < 0) {
+			crossings++
+		}
+	}
+	return float64(crossings) / 2 / (float64(len(samples)) / 22050)
+}
+
+func TestVary(t *testing.T) {
+	for _, tt := range []struct {
+		name         string
+		pitch, speed float64
+	}{
+		{"higher", 1.2, 1},
+		{"lower", 0.8, 1},
+		{"faster", 1, 1.25},
+		{"slower,higher", 1.1, 0.8},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			out := vary(sine(440, 8000), 22050, tt.pitch, tt.speed)
+			if want := 22050 / tt.speed; math.Abs(float64(len(out))-want) > 0.02*want {
+				t.Errorf("expected about %.0f samples, got %d", want, len(out))
+			}
+			if want, got := 440*tt.pitch, frequency(out); math.Abs(got-want) > 0.03*want {
+				t.Errorf("expected about %.0fHz, got %.0fHz", want, got)
+			}
+		})
+	}
+}
+
+func TestMixNoise(t *testing.T) {
+	signal := sine(440, 4000)
+	for _, noise := range []NoiseType{NoiseWhite, NoiseBabble} {
+		for _, snr := range []float64{0, 10, 20} {
+			out := mixNoise(signal, noise, snr, rand.New(rand.NewSource(1)))
+			var ps, pn float64
+			var n int
+			for i, s := range signal {
+				if s > 256 || s < -256 {
+					ps += float64(s) * float64(s)
+					n++
+				}
+				d := float64(out[i]) - float64(s)
+				pn += d * d
+			}
+			got := 10 * math.Log10((ps/float64(n))/(pn/float64(len(signal))))
+			if math.Abs(got-snr) > 0.5 {
+				t.Errorf("noise %d: expected a SNR of %vdB, got %.2fdB", noise, snr, got)
+			}
+		}
+	}
+}
+
+// segmentSpeaker toneSpeaker that records what it reads.
+type segmentSpeaker struct {
+	toneSpeaker
+	mu       sync.Mutex
+	texts    []string
+	variants []int
+}
+
+func (s *segmentSpeaker) Speak(ctx context.Context, text, lang string) ([]int16, int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.texts = append(s.texts, text)
+	v, _ := ctx.Value(VoiceVariant).(int)
+	s.variants = append(s.variants, v)
+	return s.toneSpeaker.Speak(ctx, text, lang)
+}
+
+func TestSpeak(t *testing.T) {
+	ctx := context.WithValue(context.Background(), draw.Rand, rand.New(rand.NewSource(1)))
+	for _, tt := range []struct {
+		name  string
+		src   Source
+		q     string
+		opts  AudioOptions
+		texts []string
+		// samples expected, 0 to skip
+		samples int
+	}{
+		{"plain", Random, "ab3", AudioOptions{}, []string{"ab3"}, 300},
+		{"spelled", Random, "ab3", AudioOptions{Spell: true}, []string{"a", "b", "3"}, 300},
+		{
+			"paused",
+			Random,
+			"ab3",
+			AudioOptions{Spell: true, MinPause: 10 * time.Millisecond, MaxPause: 10 * time.Millisecond},
+			[]string{"a", "b", "3"},
+			300 + 2*80,
+		},
+		{
+			"words",
+			Math,
+			"six plus 2",
+			AudioOptions{Spell: true, MaxPause: 10 * time.Millisecond},
+			[]string{"six", "plus", "2"},
+			0,
+		},
+		{"voices", QuestionBank, "what color", AudioOptions{Voices: 3}, []string{"what", "color"}, 900},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &segmentSpeaker{}
+			m := &Manager{tts: s, audio: tt.opts}
+			samples, rate, err := m.speak(ctx, tt.src, "en", tt.q)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rate != 8000 {
+				t.Errorf("expected a sample rate of 8000, got %d", rate)
+			}
+			if !reflect.DeepEqual(s.texts, tt.texts) {
+				t.Errorf("expected segments %q, got %q", tt.texts, s.texts)
+			}
+			if tt.samples > 0 && len(samples) != tt.samples {
+				t.Errorf("expected %d samples, got %d", tt.samples, len(samples))
+			}
+			for _, v := range s.variants {
+				if v < 0 || v >= tt.opts.Voices && v != 0 {
+					t.Errorf("voice variant %d out of range", v)
+				}
+			}
+		})
+	}
+}
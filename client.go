@@ -0,0 +1,158 @@
+package gotcha
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"strings"
+	"sync"
+)
+
+var (
+	// ErrClientNotFound the client is not registered.
+	ErrClientNotFound = errors.New("client not found")
+	// ErrClientRequired a client registry is set, but the request did not
+	// identify a client.
+	ErrClientRequired = errors.New("client-id is required")
+	// ErrClientMismatch the captcha belongs to a different client.
+	ErrClientMismatch = errors.New("captcha belongs to a different client")
+	// ErrOriginNotAllowed the request origin is not allowed for the client.
+	ErrOriginNotAllowed = errors.New("origin not allowed")
+	// ErrLangNotAllowed the language is not enabled for the client.
+	ErrLangNotAllowed = errors.New("language not allowed")
+)
+
+// Client is a site registered to use gotcha. The SiteKey is public and
+// embedded in the site's pages, the SecretKey is used by the site's backend
+// to verify captchas.
+type Client struct {
+	SiteKey   string `json:"site-key"`
+	SecretKey string `json:"secret-key"`
+	// Origins hostnames the client is allowed to request captchas from.
+	// Any origin is allowed if empty.
+	Origins []string `json:"origins,omitempty"`
+	// Sources overrides the manager sources if set.
+	Sources Source `json:"sources,omitempty"`
+	// Languages restricts the languages the client can request if set.
+	Languages []string `json:"languages,omitempty"`
+}
+
+// NewClient returns a *Client with random site and secret keys, allowed to
+// request captchas from origins.
+func NewClient(origins ...string) (*Client, error) {
+	site, err := randomKey(16)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := randomKey(32)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{SiteKey: site, SecretKey: secret, Origins: origins}, nil
+}
+
+func randomKey(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// AllowsOrigin reports whether the client can request captchas from
+// hostname.
+func (c *Client) AllowsOrigin(hostname string) bool {
+	if len(c.Origins) == 0 {
+		return true
+	}
+	for _, o := range c.Origins {
+		if strings.EqualFold(o, hostname) {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsLang reports whether the client can request captchas in lang.
+func (c *Client) AllowsLang(lang string) bool {
+	if len(c.Languages) == 0 {
+		return true
+	}
+	for _, l := range c.Languages {
+		if l == lang {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientStorer interface for persistent client storage.
+type ClientStorer interface {
+	CreateClient(c *Client) error
+	GetClient(siteKey string) (*Client, error)
+	UpdateClient(siteKey string, c *Client) error
+	DeleteClient(siteKey string) error
+}
+
+// NewClientStore returns an in-memory ClientStorer holding clients.
+func NewClientStore(clients ...*Client) ClientStorer {
+	cs := &defaultClientStore{clients: make(map[string]*Client)}
+	for _, c := range clients {
+		cs.clients[c.SiteKey] = c
+	}
+	return cs
+}
+
+// ReadClients reads a json file containing a list of clients.
+func ReadClients(path string) ([]*Client, error) {
+	fh, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+	var clients []*Client
+	if err := json.NewDecoder(fh).Decode(&clients); err != nil {
+		return nil, err
+	}
+	return clients, nil
+}
+
+type defaultClientStore struct {
+	sync.RWMutex
+	clients map[string]*Client
+}
+
+func (cs *defaultClientStore) CreateClient(c *Client) error {
+	cs.Lock()
+	defer cs.Unlock()
+	cs.clients[c.SiteKey] = c
+	return nil
+}
+
+func (cs *defaultClientStore) GetClient(siteKey string) (*Client, error) {
+	cs.RLock()
+	defer cs.RUnlock()
+	if c, ok := cs.clients[siteKey]; ok {
+		return c, nil
+	}
+	return nil, ErrClientNotFound
+}
+
+func (cs *defaultClientStore) UpdateClient(siteKey string, c *Client) error {
+	cs.Lock()
+	defer cs.Unlock()
+	if _, ok := cs.clients[siteKey]; !ok {
+		return ErrClientNotFound
+	}
+	cs.clients[siteKey] = c
+	return nil
+}
+
+func (cs *defaultClientStore) DeleteClient(siteKey string) error {
+	cs.Lock()
+	defer cs.Unlock()
+	delete(cs.clients, siteKey)
+	return nil
+}
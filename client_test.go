@@ -0,0 +1,105 @@
+package gotcha
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClients(t *testing.T) {
+	a := &Client{SiteKey: "a", SecretKey: "a-secret", Origins: []string{"a.com"}}
+	b := &Client{SiteKey: "b", SecretKey: "b-secret"}
+	store := &defaultStore{captchas: make(map[uint32]*Captcha)}
+	m := &Manager{
+		Store:               store,
+		Clients:             NewClientStore(a, b),
+		mountpoint:          "/gotcha",
+		lifetimeAfterPassed: time.Minute,
+	}
+	srv := httptest.NewServer(m.Router(context.Background()))
+	defer srv.Close()
+
+	store.Create(&Captcha{
+		ID:       1,
+		ClientID: "a",
+		Answers:  []string{"yes"},
+		Expiry:   time.Now().Add(time.Minute),
+	})
+
+	check := func(clientID, origin string) int {
+		body := `{"challenge-response": "yes", "client-id": "` + clientID + `"}`
+		req, _ := http.NewRequest("POST", srv.URL+"/gotcha/1/check", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Origin", origin)
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		return res.StatusCode
+	}
+	for _, tt := range []struct {
+		name     string
+		clientID string
+		origin   string
+		want     int
+	}{
+		{"no client", "", "https://a.com", 400},
+		{"unknown client", "c", "https://a.com", 403},
+		{"other client", "b", "https://a.com", 403},
+		{"origin not allowed", "a", "https://evil.com", 403},
+		{"owner", "a", "https://a.com", 200},
+	} {
+		if got := check(tt.clientID, tt.origin); got != tt.want {
+			t.Errorf("%s: expected status %d got %d", tt.name, tt.want, got)
+		}
+	}
+
+	// the client of a refresh is in its body, as in check
+	m.Sources = Random
+	m.FileStorage = &memStorage{}
+	m.random = RandomOptions{Alphabet: "ab", MinLength: 2, MaxLength: 2}
+	m.tts = NoAudio
+	store.Create(&Captcha{ID: 2, ClientID: "a", Expiry: time.Now().Add(time.Minute)})
+	refresh := func(query, body string) int {
+		req, _ := http.NewRequest("POST", srv.URL+"/gotcha/2/refresh"+query, strings.NewReader(body))
+		if body != "" {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		req.Header.Set("Origin", "https://a.com")
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		return res.StatusCode
+	}
+	if got := refresh("?client-id=a", ""); got != 400 {
+		t.Errorf("expected the query client to be ignored on refresh, got %d", got)
+	}
+	if got := refresh("", `{"client-id": "b"}`); got != 403 {
+		t.Errorf("expected another client's refresh to be rejected, got %d", got)
+	}
+	if got := refresh("", `{"client-id": "a"}`); got != 200 {
+		t.Errorf("expected the owner's refresh to pass, got %d", got)
+	}
+
+	verifyURL := srv.URL + "/gotcha/siteverify"
+	res, err := Verify(context.Background(), verifyURL, "b-secret", "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Success || res.ErrorCodes[0] != VerifyInvalidSecret {
+		t.Errorf("expected another client's secret to be rejected, got %+v", res)
+	}
+	res, err = Verify(context.Background(), verifyURL, "a-secret", "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Success || res.Hostname != "a.com" {
+		t.Errorf("expected success on a.com got %+v", res)
+	}
+}
@@ -7,6 +7,14 @@ const (
 	Expiry
 	NoGzip
 	CaptchaCtxKey
+	// ClientID site key of the client requesting the captcha (string).
+	ClientID
+	// VoiceVariant voice Speakers read a segment of the audio in (int), 0
+	// for their default voice. See AudioOptions.Voices.
+	VoiceVariant
+	// Media media generated for the challenge (MediaMode), MediaBoth if
+	// unset.
+	Media
 )
 
 const (
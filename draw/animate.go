@@ -0,0 +1,225 @@
+package draw
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color/palette"
+	"image/draw"
+	"image/gif"
+	"image/png"
+	"io"
+	"time"
+)
+
+// AnimationMode how GenAnimation animates the text.
+type AnimationMode uint8
+
+const (
+	// AnimateNoise draws the text once, and the fuzzers anew on every frame.
+	AnimateNoise AnimationMode = iota + 1
+	// AnimateReveal shows the characters one frame after the other, then
+	// the whole text for AnimationFrames frames. The fuzzers are drawn anew
+	// on every frame.
+	AnimateReveal
+)
+
+// Animation the frames of an animated captcha, shown Delay apart, looping
+// forever.
+type Animation struct {
+	Frames []draw.Image
+	Delay  time.Duration
+}
+
+// GenAnimation draws text with fd as an animation, see AnimationMode. Each
+// frame gets its own fuzzers from the factories, so that the noise moves
+// over the text. ctx checks:
+//   - Animate mode (AnimationMode), defaults to AnimateNoise
+//   - AnimationFrames
+//   - AnimationDelay
+//   - BackgroundGen, drawn once for all frames
+func GenAnimation(ctx context.Context, text string, fd FontDrawer, fuzzers ...FuzzFactory) *Animation {
+	var mode = AnimateNoise
+	if v, ok := ctx.Value(Animate).(AnimationMode); ok {
+		mode = v
+	}
+	var frames = 8
+	if v, ok := ctx.Value(AnimationFrames).(int); ok && v > 0 {
+		frames = v
+	}
+	var delay = 200 * time.Millisecond
+	if v, ok := ctx.Value(AnimationDelay).(time.Duration); ok && v > 0 {
+		delay = v
+	}
+
+	base := snapshot(fd(text))
+	bg := borderColor(base)
+	var back image.Image
+	if factory, ok := ctx.Value(BackgroundGen).(BackgroundFactory); ok && factory != nil {
+		back = factory(ctx)(base.Bounds())
+	}
+
+	// columns hidden in each frame, none when the whole text is shown
+	var hidden [][][2]int
+	if mode == AnimateReveal {
+		runs := inkRuns(base, bg)
+		for i := 1; i < len(runs); i++ {
+			hidden = append(hidden, runs[i:])
+		}
+	}
+	for i := 0; i < frames; i++ {
+		hidden = append(hidden, nil)
+	}
+
+	anim := &Animation{Delay: delay}
+	b := base.Bounds()
+	for _, runs := range hidden {
+		frame := snapshot(base)
+		for _, r := range runs {
+			draw.Draw(frame, image.Rect(r[0], b.Min.Y, r[1], b.Max.Y), &image.Uniform{bg}, image.ZP, draw.Src)
+		}
+		if back != nil {
+			applyBackground(frame, bg, back)
+		}
+		for _, f := range fuzzers {
+			f(ctx)(frame)
+		}
+		anim.Frames = append(anim.Frames, frame)
+	}
+	return anim
+}
+
+// EncodeGIF writes a as a GIF, dithered to the plan9 palette.
+func (a *Animation) EncodeGIF(w io.Writer) error {
+	g := &gif.GIF{}
+	delay := int(a.Delay / (10 * time.Millisecond))
+	for _, f := range a.Frames {
+		p := image.NewPaletted(f.Bounds(), palette.Plan9)
+		draw.FloydSteinberg.Draw(p, p.Bounds(), f, f.Bounds().Min)
+		g.Image = append(g.Image, p)
+		g.Delay = append(g.Delay, delay)
+	}
+	return gif.EncodeAll(w, g)
+}
+
+// EncodeAPNG writes a as an animated PNG, browsers without APNG support show
+// the first frame.
+func (a *Animation) EncodeAPNG(w io.Writer) error {
+	if len(a.Frames) == 0 {
+		return errors.New("draw: animation has no frames")
+	}
+	// png.Encode drops the alpha channel of opaque images, every frame must
+	// have the same color type
+	opaque := true
+	for _, f := range a.Frames {
+		if o, ok := f.(interface{ Opaque() bool }); !ok || !o.Opaque() {
+			opaque = false
+		}
+	}
+
+	var (
+		out bytes.Buffer
+		seq uint32
+	)
+	out.Write(pngSignature)
+	for i, f := range a.Frames {
+		var img image.Image = f
+		if !opaque {
+			img = translucent{f}
+		}
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, img); err != nil {
+			return err
+		}
+		chunks, err := pngChunks(buf.Bytes())
+		if err != nil {
+			return err
+		}
+		b := f.Bounds()
+		if i == 0 {
+			for _, c := range chunks {
+				if c.typ == "IHDR" {
+					writeChunk(&out, "IHDR", c.data)
+				}
+			}
+			actl := make([]byte, 8)
+			binary.BigEndian.PutUint32(actl[0:], uint32(len(a.Frames)))
+			// loop forever
+			binary.BigEndian.PutUint32(actl[4:], 0)
+			writeChunk(&out, "acTL", actl)
+		}
+		fctl := make([]byte, 26)
+		binary.BigEndian.PutUint32(fctl[0:], seq)
+		binary.BigEndian.PutUint32(fctl[4:], uint32(b.Dx()))
+		binary.BigEndian.PutUint32(fctl[8:], uint32(b.Dy()))
+		// x and y offsets 0, delay in ms, dispose and blend ops 0
+		binary.BigEndian.PutUint16(fctl[20:], uint16(a.Delay/time.Millisecond))
+		binary.BigEndian.PutUint16(fctl[22:], 1000)
+		writeChunk(&out, "fcTL", fctl)
+		seq++
+		for _, c := range chunks {
+			if c.typ != "IDAT" {
+				continue
+			}
+			if i == 0 {
+				writeChunk(&out, "IDAT", c.data)
+				continue
+			}
+			fdat := make([]byte, 4+len(c.data))
+			binary.BigEndian.PutUint32(fdat, seq)
+			copy(fdat[4:], c.data)
+			writeChunk(&out, "fdAT", fdat)
+			seq++
+		}
+	}
+	writeChunk(&out, "IEND", nil)
+	_, err := out.WriteTo(w)
+	return err
+}
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// translucent hides the Opaque method of an image, so that png.Encode
+// keeps its alpha channel.
+type translucent struct {
+	image.Image
+}
+
+type pngChunk struct {
+	typ  string
+	data []byte
+}
+
+// pngChunks splits an encoded PNG into its chunks.
+func pngChunks(b []byte) ([]pngChunk, error) {
+	if !bytes.HasPrefix(b, pngSignature) {
+		return nil, errors.New("draw: not a PNG")
+	}
+	b = b[len(pngSignature):]
+	var chunks []pngChunk
+	for len(b) >= 12 {
+		n := int(binary.BigEndian.Uint32(b))
+		if len(b) < 12+n {
+			return nil, errors.New("draw: truncated PNG chunk")
+		}
+		chunks = append(chunks, pngChunk{string(b[4:8]), b[8 : 8+n]})
+		b = b[12+n:]
+	}
+	return chunks, nil
+}
+
+func writeChunk(w *bytes.Buffer, typ string, data []byte) {
+	var n [4]byte
+	binary.BigEndian.PutUint32(n[:], uint32(len(data)))
+	w.Write(n[:])
+	crc := crc32.NewIEEE()
+	crc.Write([]byte(typ))
+	crc.Write(data)
+	w.WriteString(typ)
+	w.Write(data)
+	binary.BigEndian.PutUint32(n[:], crc.Sum32())
+	w.Write(n[:])
+}
@@ -0,0 +1,248 @@
+package draw
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"image/draw"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+
+	// texture decoders
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+)
+
+// Background returns the background for an image with bounds r.
+type Background func(r image.Rectangle) image.Image
+
+// BackgroundFactory helper closure that passes a (potentially) unique
+// context to the Background, set it under the BackgroundGen key.
+type BackgroundFactory func(ctx context.Context) Background
+
+// backgroundColors reads the BackgroundColor and BackgroundColor2 keys.
+func backgroundColors(ctx context.Context) (c1, c2 color.RGBA) {
+	c1 = color.RGBA{192, 192, 192, 255}
+	if v, ok := ctx.Value(BackgroundColor).(color.RGBA); ok {
+		c1 = v
+	}
+	c2 = color.RGBA{232, 232, 232, 255}
+	if v, ok := ctx.Value(BackgroundColor2).(color.RGBA); ok {
+		c2 = v
+	}
+	return
+}
+
+func mixRGBA(t float64, a, b color.RGBA) color.RGBA {
+	mix := func(a, b uint8) uint8 {
+		return uint8(math.Round(lerp(t, float64(a), float64(b))))
+	}
+	return color.RGBA{mix(a.R, b.R), mix(a.G, b.G), mix(a.B, b.B), mix(a.A, b.A)}
+}
+
+// PerlinBackground blends BackgroundColor and BackgroundColor2 with perlin
+// noise, giving a cloudy texture. ctx reads BackgroundScale, the size of
+// the clouds in pixels.
+func PerlinBackground(ctx context.Context) Background {
+	c1, c2 := backgroundColors(ctx)
+	var scale = 32.0
+	if v, ok := ctx.Value(BackgroundScale).(float64); ok && v > 0 {
+		scale = v
+	}
+	rnd := rng(ctx)
+	return func(r image.Rectangle) image.Image {
+		p := newPerlin(rnd)
+		img := image.NewRGBA(r)
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			for x := r.Min.X; x < r.Max.X; x++ {
+				t := (p.octaves(float64(x)/scale, float64(y)/scale, 3) + 1) / 2
+				img.SetRGBA(x, y, mixRGBA(t, c1, c2))
+			}
+		}
+		return img
+	}
+}
+
+// GradientBackground fades from BackgroundColor to BackgroundColor2 in a
+// random direction.
+func GradientBackground(ctx context.Context) Background {
+	c1, c2 := backgroundColors(ctx)
+	rnd := rng(ctx)
+	return func(r image.Rectangle) image.Image {
+		angle := rnd.Float64() * 2 * math.Pi
+		dx, dy := math.Cos(angle), math.Sin(angle)
+		// project the corners to find the extent of the gradient
+		lo, hi := math.Inf(1), math.Inf(-1)
+		for _, pt := range []image.Point{r.Min, {r.Max.X, r.Min.Y}, {r.Min.X, r.Max.Y}, r.Max} {
+			d := float64(pt.X)*dx + float64(pt.Y)*dy
+			lo, hi = math.Min(lo, d), math.Max(hi, d)
+		}
+		img := image.NewRGBA(r)
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			for x := r.Min.X; x < r.Max.X; x++ {
+				t := (float64(x)*dx + float64(y)*dy - lo) / (hi - lo)
+				img.SetRGBA(x, y, mixRGBA(t, c1, c2))
+			}
+		}
+		return img
+	}
+}
+
+// SpeckleBackground scatters dots of BackgroundColor2 over BackgroundColor.
+// ctx reads BackgroundDensity, the fraction of the pixels speckled.
+func SpeckleBackground(ctx context.Context) Background {
+	c1, c2 := backgroundColors(ctx)
+	var density = 0.05
+	if v, ok := ctx.Value(BackgroundDensity).(float64); ok {
+		density = normalizeNoise(v)
+	}
+	rnd := rng(ctx)
+	return func(r image.Rectangle) image.Image {
+		img := image.NewRGBA(r)
+		draw.Draw(img, r, &image.Uniform{c1}, image.ZP, draw.Src)
+		dots := int(float64(r.Dx()*r.Dy()) * density / 3)
+		for i := 0; i < dots; i++ {
+			x, y := r.Min.X+rnd.Intn(r.Dx()), r.Min.Y+rnd.Intn(r.Dy())
+			// 1 or 2px dots
+			size := 1 + rnd.Intn(2)
+			draw.Draw(img, image.Rect(x, y, x+size, y+size), &image.Uniform{c2}, image.ZP, draw.Src)
+		}
+		return img
+	}
+}
+
+// TextureBackground tiles a random image from the BackgroundTextureDir
+// directory (png, jpeg or gif), falling back to BackgroundColor if there are
+// none.
+func TextureBackground(ctx context.Context) Background {
+	c1, _ := backgroundColors(ctx)
+	dir, _ := ctx.Value(BackgroundTextureDir).(string)
+	rnd := rng(ctx)
+	return func(r image.Rectangle) image.Image {
+		img := image.NewRGBA(r)
+		tex := randomTexture(rnd, dir)
+		if tex == nil {
+			draw.Draw(img, r, &image.Uniform{c1}, image.ZP, draw.Src)
+			return img
+		}
+		tb := tex.Bounds()
+		ox, oy := rnd.Intn(tb.Dx()), rnd.Intn(tb.Dy())
+		for y := r.Min.Y - oy; y < r.Max.Y; y += tb.Dy() {
+			for x := r.Min.X - ox; x < r.Max.X; x += tb.Dx() {
+				draw.Draw(img, tb.Sub(tb.Min).Add(image.Pt(x, y)), tex, tb.Min, draw.Src)
+			}
+		}
+		return img
+	}
+}
+
+// randomTexture decodes a random image from dir, nil if there are none.
+func randomTexture(rnd *rand.Rand, dir string) image.Image {
+	if dir == "" {
+		return nil
+	}
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	var paths []string
+	for _, e := range entries {
+		switch strings.ToLower(filepath.Ext(e.Name())) {
+		case ".png", ".jpg", ".jpeg", ".gif":
+			paths = append(paths, filepath.Join(dir, e.Name()))
+		}
+	}
+	for len(paths) > 0 {
+		i := rnd.Intn(len(paths))
+		if tex := decodeFile(paths[i]); tex != nil && !tex.Bounds().Empty() {
+			return tex
+		}
+		paths = append(paths[:i], paths[i+1:]...)
+	}
+	return nil
+}
+
+func decodeFile(path string) image.Image {
+	fh, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer fh.Close()
+	img, _, err := image.Decode(fh)
+	if err != nil {
+		return nil
+	}
+	return img
+}
+
+// borderColor returns the most common color on the border of img, taken as
+// the background the FontDrawer filled it with.
+func borderColor(img image.Image) color.RGBA {
+	b := img.Bounds()
+	counts := map[color.RGBA]int{}
+	var best color.RGBA
+	count := func(x, y int) {
+		c := color.RGBAModel.Convert(img.At(x, y)).(color.RGBA)
+		counts[c]++
+		if counts[c] > counts[best] {
+			best = c
+		}
+	}
+	for x := b.Min.X; x < b.Max.X; x++ {
+		count(x, b.Min.Y)
+		count(x, b.Max.Y-1)
+	}
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		count(b.Min.X, y)
+		count(b.Max.X-1, y)
+	}
+	return best
+}
+
+// applyBackground replaces the bg colored background of img with back. The
+// glyphs are assumed to be the pixels farthest from bg, their antialiased
+// edges are blended into back.
+func applyBackground(img draw.Image, bg color.RGBA, back image.Image) {
+	b := img.Bounds()
+	dist := func(c color.RGBA) float64 {
+		d := math.Abs(float64(c.R) - float64(bg.R))
+		d = math.Max(d, math.Abs(float64(c.G)-float64(bg.G)))
+		d = math.Max(d, math.Abs(float64(c.B)-float64(bg.B)))
+		return d
+	}
+	src := snapshot(img)
+	var farthest float64
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			farthest = math.Max(farthest, dist(src.RGBAAt(x, y)))
+		}
+	}
+	clamp := func(v float64) uint8 {
+		return uint8(math.Max(0, math.Min(255, math.Round(v))))
+	}
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := src.RGBAAt(x, y)
+			// how much of bg shows through c
+			t := 1.0
+			if farthest > 0 {
+				t = 1 - dist(c)/farthest
+			}
+			if t == 0 {
+				continue
+			}
+			tc := color.RGBAModel.Convert(back.At(x, y)).(color.RGBA)
+			img.Set(x, y, color.RGBA{
+				clamp(float64(c.R) + t*(float64(tc.R)-float64(bg.R))),
+				clamp(float64(c.G) + t*(float64(tc.G)-float64(bg.G))),
+				clamp(float64(c.B) + t*(float64(tc.B)-float64(bg.B))),
+				clamp(float64(c.A) + t*(float64(tc.A)-float64(bg.A))),
+			})
+		}
+	}
+}
@@ -12,7 +12,6 @@ import (
 	"image/png"
 	"math"
 	"math/rand"
-	"sync"
 	"time"
 )
 
@@ -26,9 +25,25 @@ func init() {
 	rand.Seed(time.Now().Unix())
 }
 
+// globalSource is the top level math/rand source, safe for concurrent use.
+type globalSource struct{}
+
+func (globalSource) Int63() int64    { return rand.Int63() }
+func (globalSource) Uint64() uint64  { return rand.Uint64() }
+func (globalSource) Seed(seed int64) {}
+
+// rng returns the *math/rand.Rand under the Rand key, or one backed by the
+// top level math/rand functions if there is none.
+func rng(ctx context.Context) *rand.Rand {
+	if r, ok := ctx.Value(Rand).(*rand.Rand); ok && r != nil {
+		return r
+	}
+	return rand.New(globalSource{})
+}
+
 // GenBase64 returns the base64 encoding of the image with text in it.
 func GenBase64(text string, fd FontDrawer, fuzzers ...Fuzzer) (b64 string) {
-	captcha := gen(text, fd, fuzzers...)
+	captcha := gen(context.Background(), text, fd, fuzzers...)
 	var buf bytes.Buffer
 	png.Encode(&buf, captcha)
 	b64 = base64.StdEncoding.EncodeToString(buf.Bytes())
@@ -37,7 +52,13 @@ func GenBase64(text string, fd FontDrawer, fuzzers ...Fuzzer) (b64 string) {
 
 // Gen returns an image/draw.Image the image with text in it.
 func Gen(text string, fd FontDrawer, fuzzers ...Fuzzer) draw.Image {
-	return gen(text, fd, fuzzers...)
+	return gen(context.Background(), text, fd, fuzzers...)
+}
+
+// GenContext same as Gen, but draws a background before applying the
+// fuzzers if ctx has a BackgroundFactory under the BackgroundGen key.
+func GenContext(ctx context.Context, text string, fd FontDrawer, fuzzers ...Fuzzer) draw.Image {
+	return gen(ctx, text, fd, fuzzers...)
 }
 
 // FontDrawer is the functype that draws fonts into the image. It's
@@ -45,21 +66,18 @@ func Gen(text string, fd FontDrawer, fuzzers ...Fuzzer) draw.Image {
 // the size.
 type FontDrawer func(string) draw.Image
 
-var genWG sync.WaitGroup
-
-func gen(text string, fd FontDrawer, fuzzers ...Fuzzer) draw.Image {
+// gen draws text with fd, replaces its flat background with the one from the
+// BackgroundGen key, if any, and applies fuzzers in order, as
+// the warping fuzzers (SineWave, ElasticWarp, Crowd) deform whatever was
+// drawn before them.
+func gen(ctx context.Context, text string, fd FontDrawer, fuzzers ...Fuzzer) draw.Image {
 	captcha := fd(text)
-
+	if factory, ok := ctx.Value(BackgroundGen).(BackgroundFactory); ok && factory != nil {
+		applyBackground(captcha, borderColor(captcha), factory(ctx)(captcha.Bounds()))
+	}
 	for _, fuzzer := range fuzzers {
-		genWG.Add(1)
-		fn := fuzzer
-		go func() {
-			fn(captcha)
-			defer genWG.Done()
-		}()
+		fuzzer(captcha)
 	}
-	genWG.Wait()
-
 	return captcha
 }
 
@@ -96,6 +114,51 @@ const (
 	RandomRotation
 	// BackgroundColor set the bacground color for mask image. (image/color.RGBA)
 	BackgroundColor
+	// FontSize font size in points, for OpenType fonts. (float64)
+	FontSize
+	// FontColor color of the glyphs, for OpenType fonts. (image/color.RGBA)
+	FontColor
+	// GlyphJitter maximum offset in pixels of each glyph from its position,
+	// for OpenType fonts. (int)
+	GlyphJitter
+	// WaveAmplitude maximum displacement in pixels of SineWave. (float64)
+	WaveAmplitude
+	// WavePeriod wavelength in pixels of SineWave. (float64)
+	WavePeriod
+	// WarpStrength maximum displacement in pixels of ElasticWarp. (float64)
+	WarpStrength
+	// WarpScale size in pixels of the features of ElasticWarp, larger values
+	// give smoother warps. (float64)
+	WarpScale
+	// CrowdOverlap pixels each glyph overlaps the previous one in Crowd. (int)
+	CrowdOverlap
+	// BackgroundGen background to draw behind the text, before the fuzzers
+	// are applied. (BackgroundFactory)
+	BackgroundGen
+	// BackgroundColor2 second color of the generated backgrounds, blended
+	// with BackgroundColor. (image/color.RGBA)
+	BackgroundColor2
+	// BackgroundScale size in pixels of the PerlinBackground clouds. (float64)
+	BackgroundScale
+	// BackgroundDensity fraction of the pixels SpeckleBackground covers with
+	// dots, 0 to 1. (float64)
+	BackgroundDensity
+	// BackgroundTextureDir directory with the png, jpeg or gif images
+	// TextureBackground tiles. (string)
+	BackgroundTextureDir
+	// Rand source of every random choice of the FontDrawers, Fuzzers and
+	// Backgrounds created with the context, e.g.
+	// rand.New(rand.NewSource(seed)). The same seed, text and options
+	// produce the same image. A *math/rand.Rand is not safe for concurrent
+	// use, use one per image. Defaults to the top level math/rand functions.
+	// (*math/rand.Rand)
+	Rand
+	// Animate how GenAnimation animates the text. (AnimationMode)
+	Animate
+	// AnimationFrames frames GenAnimation draws with the whole text. (int)
+	AnimationFrames
+	// AnimationDelay time between the frames of GenAnimation. (time.Duration)
+	AnimationDelay
 )
 
 // RandomLines draws random lines over img. ctx reads noise and color keys.
@@ -109,21 +172,16 @@ func RandomLines(ctx context.Context) Fuzzer {
 	if n, ok := ctx.Value(FuzzNoiseCtxKey).(float64); ok {
 		noise = normalizeNoise(n)
 	}
+	rnd := rng(ctx)
 
 	return func(img draw.Image) {
 		lines := image.NewRGBA(img.Bounds())
 
-		var wg sync.WaitGroup
 		for i := 0; i < int(noise*100); i++ {
-			wg.Add(1)
-			func() {
-				defer wg.Done()
-				x0, y0 := rand.Intn(lines.Rect.Max.X), rand.Intn(lines.Rect.Max.Y)
-				x1, y1 := rand.Intn(lines.Rect.Max.X), rand.Intn(lines.Rect.Max.Y)
-				drawLine(lines, x0, y0, x1, y1, col)
-			}()
+			x0, y0 := rnd.Intn(lines.Rect.Max.X), rnd.Intn(lines.Rect.Max.Y)
+			x1, y1 := rnd.Intn(lines.Rect.Max.X), rnd.Intn(lines.Rect.Max.Y)
+			drawLine(lines, x0, y0, x1, y1, col)
 		}
-		wg.Wait()
 		draw.Draw(img, img.Bounds(), lines, image.ZP, draw.Over)
 	}
 }
@@ -134,33 +192,26 @@ func RandomCircles(ctx context.Context) Fuzzer {
 	if n, ok := ctx.Value(FuzzNoiseCtxKey).(float64); ok {
 		noise = normalizeNoise(n)
 	}
+	rnd := rng(ctx)
 	var col color.RGBA
 	if c, ok := ctx.Value(FuzzColor1CtxKey).(color.RGBA); ok {
 		col = c
 	} else {
-		var k uint8 = uint8(rand.Intn(128))
+		var k uint8 = uint8(rnd.Intn(128))
 		col = color.RGBA{k, k, k, k}
 	}
 	return func(img draw.Image) {
 
 		circles := image.NewRGBA(img.Bounds())
 
-		var wg sync.WaitGroup
 		for i := 0; i < int(math.Round(noise*10)); i++ {
-			// for i := 0; i < 1; i++ {
-			wg.Add(1)
-			r := rand.Intn(circles.Rect.Max.Y)
-			cx := rand.Intn(circles.Rect.Max.X)
-			cy := rand.Intn(circles.Rect.Max.Y)
-			go func(r, cx, cy int) {
-				defer wg.Done()
-
-				for rr := r; rr > 0; rr-- {
-					drawCircle(circles, cx, cy, rr, col)
-				}
-			}(r, cx, cy)
+			r := rnd.Intn(circles.Rect.Max.Y)
+			cx := rnd.Intn(circles.Rect.Max.X)
+			cy := rnd.Intn(circles.Rect.Max.Y)
+			for rr := r; rr > 0; rr-- {
+				drawCircle(circles, cx, cy, rr, col)
+			}
 		}
-		wg.Wait()
 		draw.Draw(img, img.Bounds(), circles, image.ZP, draw.Over)
 
 	}
@@ -185,36 +236,32 @@ func ConcentricCircles(ctx context.Context) Fuzzer {
 	if n, ok := ctx.Value(FuzzBandThicknessCtxKey).(int); ok {
 		thickness = n
 	}
+	rnd := rng(ctx)
 	return func(img draw.Image) {
 
 		circles := image.NewRGBA(img.Bounds())
 
-		var wg sync.WaitGroup
+		// overlapping rings are drawn in order, so that the image is the same
+		// for the same Rand
 		for i := 0; i < int(math.Round(noise*20)); i++ {
-			// for i := 0; i < 1; i++ {
-			wg.Add(1)
-			r := rand.Intn(circles.Rect.Max.Y)
-			cx := rand.Intn(circles.Rect.Max.X)
-			cy := rand.Intn(circles.Rect.Max.Y)
-			go func(r, cx, cy int, col1, col2 color.Color) {
-				defer wg.Done()
-
-				swtch := true
-				var col color.Color
-				for j := r; j >= 0; j -= thickness {
-					if swtch {
-						col = col1
-					} else {
-						col = col2
-					}
-					for rr := j; rr >= j-thickness; rr-- {
-						drawCircle(circles, cx, cy, rr, col)
-					}
-					swtch = !swtch
+			r := rnd.Intn(circles.Rect.Max.Y)
+			cx := rnd.Intn(circles.Rect.Max.X)
+			cy := rnd.Intn(circles.Rect.Max.Y)
+
+			swtch := true
+			var col color.Color
+			for j := r; j >= 0; j -= thickness {
+				if swtch {
+					col = col1
+				} else {
+					col = col2
+				}
+				for rr := j; rr >= j-thickness; rr-- {
+					drawCircle(circles, cx, cy, rr, col)
 				}
-			}(r, cx, cy, col1, col2)
+				swtch = !swtch
+			}
 		}
-		wg.Wait()
 		draw.Draw(img, img.Bounds(), circles, image.ZP, draw.Over)
 	}
 }
@@ -259,25 +306,21 @@ func AccurateBands(ctx context.Context) Fuzzer {
 		}
 		var swtch bool = true
 
-		var wg sync.WaitGroup
 		var col color.RGBA
 
+		// neighbouring bands overlap, draw them in order
 		for j := -thickness; j < bands.Rect.Max.X*2+thickness; j += thickness {
 			if swtch {
 				col = col1
 			} else {
 				col = col2
 			}
-			wg.Add(1)
-			go func(b int, col color.RGBA) {
-				defer wg.Done()
-				for i := 0; i < thickness; i++ {
-					for x := -thickness; x <= width; x++ {
-						y := -lineEqY(slope, x+i, b) - i
-						bands.Set(x+i, y, col)
-					}
+			for i := 0; i < thickness; i++ {
+				for x := -thickness; x <= width; x++ {
+					y := -lineEqY(slope, x+i, intercept) - i
+					bands.Set(x+i, y, col)
 				}
-			}(intercept, col)
+			}
 			if slope < 0 {
 				intercept += thickness
 			} else {
@@ -285,7 +328,6 @@ func AccurateBands(ctx context.Context) Fuzzer {
 			}
 			swtch = !swtch
 		}
-		wg.Wait()
 		draw.Draw(img, img.Bounds(), bands, image.ZP, draw.Over)
 	}
 }
@@ -314,9 +356,9 @@ func Bands(ctx context.Context) Fuzzer {
 	return func(img draw.Image) {
 		bands := image.NewRGBA(img.Bounds())
 
-		var wg sync.WaitGroup
 		var col color.RGBA
 
+		// neighbouring lines overlap, draw them in order
 		x := -thickness
 		y := 0
 		i := 0
@@ -335,17 +377,13 @@ func Bands(ctx context.Context) Fuzzer {
 			b := lineEqB(slope, x, y)
 			y1 := -lineEqY(slope, x+thickness, b)
 			x1 := -lineEqX(slope, -y1, -b)
-			wg.Add(1)
-			go func(x, y, x1, y2 int, col color.RGBA) {
-				defer wg.Done()
-				drawLine(bands,
-					x,
-					y,
-					x1,
-					y1,
-					col,
-				)
-			}(x, y, x1, y1, col)
+			drawLine(bands,
+				x,
+				y,
+				x1,
+				y1,
+				col,
+			)
 			if y == bands.Rect.Max.Y {
 				x++
 			}
@@ -354,7 +392,6 @@ func Bands(ctx context.Context) Fuzzer {
 			}
 			i++
 		}
-		wg.Wait()
 		draw.Draw(img, img.Bounds(), bands, image.ZP, draw.Over)
 	}
 }
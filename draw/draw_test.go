@@ -1,10 +1,25 @@
 package draw
 
 import (
+	"bytes"
 	"context"
+	"flag"
 	"image"
 	"image/color"
+	"image/draw"
+	"image/gif"
+	"image/png"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/webp"
 )
 
 func BenchmarkBands(b *testing.B) {
@@ -56,6 +71,52 @@ func BenchmarkBands(b *testing.B) {
 	}
 }
 
+func BenchmarkRotate(b *testing.B) {
+	for _, bb := range []struct {
+		name string
+		w, h int
+		deg  int
+	}{
+		{"30deg,50x50", 50, 50, 30},
+		{"30deg,100x100", 100, 100, 30},
+		{"30deg,200x200", 200, 200, 30},
+		{"90deg,100x100", 100, 100, 90},
+		{"90deg,200x200", 200, 200, 90},
+	} {
+		img := image.NewRGBA(image.Rect(0, 0, bb.w, bb.h))
+		draw.Draw(img, img.Bounds(), image.Black, image.ZP, draw.Src)
+		ctx := context.Background()
+		b.Run(bb.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				Rotate(ctx, img, bb.deg)
+			}
+		})
+	}
+}
+
+func BenchmarkScale(b *testing.B) {
+	for _, bb := range []struct {
+		name string
+		w, h int
+		c    float64
+	}{
+		{"0.5x,100x100", 100, 100, 0.5},
+		{"0.5x,200x200", 200, 200, 0.5},
+		{"2x,50x50", 50, 50, 2},
+		{"2x,100x100", 100, 100, 2},
+		{"3x,100x100", 100, 100, 3},
+	} {
+		img := image.NewRGBA(image.Rect(0, 0, bb.w, bb.h))
+		draw.Draw(img, img.Bounds(), image.Black, image.ZP, draw.Src)
+		ctx := context.Background()
+		b.Run(bb.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				Scale(ctx, img, bb.c)
+			}
+		})
+	}
+}
+
 func BenchmarkAccurateBands(b *testing.B) {
 	for _, bb := range []struct {
 		name       string
@@ -218,3 +279,467 @@ func BenchmarkConcentricCircles(b *testing.B) {
 		ctx = nil
 	}
 }
+
+func TestOpenType(t *testing.T) {
+	regular, err := ParseFont(goregular.TTF)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bold, err := ParseFont(gobold.TTF)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fg := color.RGBA{0, 0, 0, 255}
+	ctx := context.WithValue(context.Background(), FontColor, fg)
+	ctx = context.WithValue(ctx, GlyphJitter, 3)
+	fd, err := OpenType(ctx, regular, bold)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img := Gen("hello world", fd)
+	if b := img.Bounds(); b.Dx() < 11*10 || b.Dy() < 32 {
+		t.Fatalf("expected room for 11 glyphs of 32pt, got %v", b)
+	}
+	var inked int
+	for x := 0; x < img.Bounds().Dx(); x++ {
+		for y := 0; y < img.Bounds().Dy(); y++ {
+			if img.At(x, y) == fg {
+				inked++
+			}
+		}
+	}
+	if inked == 0 {
+		t.Error("no glyphs drawn")
+	}
+
+	if _, err := OpenType(ctx); err == nil {
+		t.Error("expected an error without fonts")
+	}
+	if _, err := OpenType(context.WithValue(ctx, FontSize, 0.0), regular); err == nil {
+		t.Error("expected an error for a zero font size")
+	}
+}
+
+func TestBreakLines(t *testing.T) {
+	for _, tt := range []struct {
+		text  string
+		count int
+		want  []string
+	}{
+		{"short", 18, []string{"short"}},
+		{"one two three four", 6, []string{"one two", "three four"}},
+		{"ñandú ñandú ñandú", 5, []string{"ñandú ñandú", "ñandú"}},
+		{"a\nb", 18, []string{"a", "b"}},
+	} {
+		got, _ := breakLines(tt.text, tt.count)
+		if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+			t.Errorf("breakLines(%q, %d) = %q, want %q", tt.text, tt.count, got, tt.want)
+		}
+	}
+	if _, longest := breakLines("été", 18); longest != 3 {
+		t.Errorf("expected combining marks not to count, got %d", longest)
+	}
+}
+
+func TestLayout(t *testing.T) {
+	for _, tt := range []struct {
+		text string
+		want string
+	}{
+		{"abc (12)", "abc (12)"},
+		{"שלום", "םולש"},
+		{"שלום 123 (א)", "(א) 123 םולש"},
+		{"hello שלום world", "hello םולש world"},
+		// beh, yeh and teh join into initial, medial and final forms
+		{"بيت", "ﺖﻴﺑ"},
+		// lam alef ligature
+		{"لا", "ﻻ"},
+	} {
+		lines, _ := layout(tt.text, 18)
+		if got := strings.Join(lines[0], ""); got != tt.want {
+			t.Errorf("layout(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestWarps(t *testing.T) {
+	bg := color.RGBA{192, 192, 192, 255}
+	fg := color.RGBA{0, 0, 0, 255}
+	// two 10px wide bars, 20px apart
+	newImg := func() *image.RGBA {
+		img := image.NewRGBA(image.Rect(0, 0, 60, 40))
+		for x := 0; x < 60; x++ {
+			for y := 0; y < 40; y++ {
+				img.Set(x, y, bg)
+				if (x >= 10 && x < 20) || (x >= 40 && x < 50) {
+					img.Set(x, y, fg)
+				}
+			}
+		}
+		return img
+	}
+	inkedColumns := func(img *image.RGBA) (first, last int) {
+		first = -1
+		for x := 0; x < 60; x++ {
+			for y := 0; y < 40; y++ {
+				if img.RGBAAt(x, y) != bg {
+					if first < 0 {
+						first = x
+					}
+					last = x
+					break
+				}
+			}
+		}
+		return
+	}
+	ctx := context.Background()
+
+	for name, ff := range map[string]FuzzFactory{"SineWave": SineWave, "ElasticWarp": ElasticWarp} {
+		img := newImg()
+		ff(ctx)(img)
+		if img.Bounds() != image.Rect(0, 0, 60, 40) {
+			t.Errorf("%s changed the bounds to %v", name, img.Bounds())
+		}
+		straight := true
+		for y := 0; y < 40; y++ {
+			if img.RGBAAt(9, y) != bg || img.RGBAAt(10, y) != fg {
+				straight = false
+			}
+		}
+		if straight {
+			t.Errorf("%s did not bend the bars", name)
+		}
+	}
+
+	img := newImg()
+	Crowd(context.WithValue(ctx, CrowdOverlap, 2))(img)
+	// 10 + 10 - 2 px wide, centered
+	if first, last := inkedColumns(img); first != 21 || last != 38 {
+		t.Errorf("expected bars crowded into columns 21-38, got %d-%d", first, last)
+	}
+}
+
+func TestBackgrounds(t *testing.T) {
+	bg := color.RGBA{192, 192, 192, 255}
+	fg := color.RGBA{0, 0, 0, 255}
+	// a 10px wide bar on bg
+	fd := func(string) draw.Image {
+		img := image.NewRGBA(image.Rect(0, 0, 60, 40))
+		for x := 0; x < 60; x++ {
+			for y := 0; y < 40; y++ {
+				img.Set(x, y, bg)
+				if x >= 25 && x < 35 {
+					img.Set(x, y, fg)
+				}
+			}
+		}
+		return img
+	}
+	dir := t.TempDir()
+	tex := image.NewRGBA(image.Rect(0, 0, 7, 7))
+	for i := range tex.Pix {
+		tex.Pix[i] = uint8(i * 13)
+	}
+	fh, err := os.Create(filepath.Join(dir, "texture.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	png.Encode(fh, tex)
+	fh.Close()
+
+	for name, bf := range map[string]BackgroundFactory{
+		"Perlin":   PerlinBackground,
+		"Gradient": GradientBackground,
+		"Speckle":  SpeckleBackground,
+		"Texture":  TextureBackground,
+	} {
+		ctx := context.WithValue(context.Background(), BackgroundGen, bf)
+		ctx = context.WithValue(ctx, BackgroundTextureDir, dir)
+		img := GenContext(ctx, "", fd).(*image.RGBA)
+		if img.Bounds() != image.Rect(0, 0, 60, 40) {
+			t.Errorf("%s changed the bounds to %v", name, img.Bounds())
+		}
+		flat := true
+		for x := 0; x < 60; x++ {
+			for y := 0; y < 40; y++ {
+				c := img.RGBAAt(x, y)
+				if x >= 25 && x < 35 && c != fg {
+					t.Errorf("%s drew over the text at %d,%d: %v", name, x, y, c)
+				}
+				if (x < 25 || x >= 35) && c != bg {
+					flat = false
+				}
+			}
+		}
+		if flat {
+			t.Errorf("%s did not draw a background", name)
+		}
+	}
+}
+
+var update = flag.Bool("update", false, "update the golden images in testdata/golden")
+
+// TestGolden draws every FontDrawer, Fuzzer and Background with a seeded
+// Rand, and compares the images with the ones in testdata/golden.
+func TestGolden(t *testing.T) {
+	regular, err := ParseFont(goregular.TTF)
+	if err != nil {
+		t.Fatal(err)
+	}
+	openType := func(ctx context.Context, fonts ...*Font) FontDrawer {
+		fd, err := OpenType(ctx, fonts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fd
+	}
+	base := context.WithValue(context.Background(), RandomRotation, true)
+	base = context.WithValue(base, LineBreak, 18)
+	cases := []struct {
+		name    string
+		bg      BackgroundFactory
+		fd      func(ctx context.Context) FontDrawer
+		fuzzers []FuzzFactory
+	}{
+		{
+			"inconsolata_bands_lines",
+			nil,
+			func(ctx context.Context) FontDrawer { return Inconsolata(ctx) },
+			[]FuzzFactory{Bands, AccurateBands, RandomLines},
+		},
+		{
+			"inconsolata_circles",
+			nil,
+			func(ctx context.Context) FontDrawer { return Inconsolata(ctx) },
+			[]FuzzFactory{RandomCircles, ConcentricCircles},
+		},
+		{
+			"opentype_warps",
+			PerlinBackground,
+			func(ctx context.Context) FontDrawer {
+				return openType(context.WithValue(ctx, GlyphJitter, 3), regular)
+			},
+			[]FuzzFactory{SineWave, ElasticWarp, Crowd},
+		},
+		{
+			"opentype_speckle",
+			SpeckleBackground,
+			func(ctx context.Context) FontDrawer { return openType(ctx, regular) },
+			[]FuzzFactory{RandomLines},
+		},
+		{
+			"opentype_gradient",
+			GradientBackground,
+			func(ctx context.Context) FontDrawer { return openType(ctx, regular) },
+			nil,
+		},
+	}
+	render := func(bg BackgroundFactory, fd func(context.Context) FontDrawer, fuzzers []FuzzFactory) []byte {
+		ctx := context.WithValue(base, Rand, rand.New(rand.NewSource(1)))
+		if bg != nil {
+			ctx = context.WithValue(ctx, BackgroundGen, bg)
+		}
+		var fz []Fuzzer
+		for _, f := range fuzzers {
+			fz = append(fz, f(ctx))
+		}
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, GenContext(ctx, "gotcha 42 Ünïcode", fd(ctx), fz...)); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := render(tc.bg, tc.fd, tc.fuzzers)
+			if again := render(tc.bg, tc.fd, tc.fuzzers); !bytes.Equal(got, again) {
+				t.Fatal("same seed rendered different images")
+			}
+			path := filepath.Join("testdata", "golden", tc.name+".png")
+			if *update {
+				if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := ioutil.WriteFile(path, got, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := ioutil.ReadFile(path)
+			if err != nil {
+				t.Fatalf("%v, run go test -update to create it", err)
+			}
+			// compare pixels, the PNG encoding may change between go versions
+			gotImg, _ := png.Decode(bytes.NewReader(got))
+			wantImg, err := png.Decode(bytes.NewReader(want))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !equalImages(gotImg, wantImg) {
+				t.Errorf("image differs from %s, run go test -update if the change is intended", path)
+			}
+		})
+	}
+}
+
+func equalImages(a, b image.Image) bool {
+	if a.Bounds() != b.Bounds() {
+		return false
+	}
+	r := a.Bounds()
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			if color.RGBAModel.Convert(a.At(x, y)) != color.RGBAModel.Convert(b.At(x, y)) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// TestConcurrentGen draws with every Inconsolata variant from several
+// goroutines, run it with -race.
+func TestConcurrentGen(t *testing.T) {
+	variants := []FontVariant{VariantInconsolataGray, VariantInconsolataBlack, VariantInconsolataInverted}
+	render := func(variant FontVariant, seed int64) []byte {
+		ctx := context.WithValue(context.Background(), FontVariantCtxKey, variant)
+		ctx = context.WithValue(ctx, Rand, rand.New(rand.NewSource(seed)))
+		ctx = context.WithValue(ctx, BackgroundGen, BackgroundFactory(SpeckleBackground))
+		var fz []Fuzzer
+		for _, f := range []FuzzFactory{Bands, RandomCircles, ConcentricCircles, RandomLines, SineWave} {
+			fz = append(fz, f(ctx))
+		}
+		var buf bytes.Buffer
+		png.Encode(&buf, GenContext(ctx, "race free", Inconsolata(ctx), fz...))
+		return buf.Bytes()
+	}
+	want := make([][]byte, len(variants))
+	for i, v := range variants {
+		want[i] = render(v, int64(i))
+	}
+	var wg sync.WaitGroup
+	for n := 0; n < 4; n++ {
+		for i, v := range variants {
+			wg.Add(1)
+			go func(i int, v FontVariant) {
+				defer wg.Done()
+				if !bytes.Equal(render(v, int64(i)), want[i]) {
+					t.Errorf("variant %d drew a different image concurrently", v)
+				}
+			}(i, v)
+		}
+	}
+	wg.Wait()
+}
+
+func TestSharedDrawer(t *testing.T) {
+	ctx := context.WithValue(context.Background(), RandomRotation, false)
+	want := Inconsolata(ctx)("abc").(*image.RGBA).Pix
+	fd := Inconsolata(ctx)
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for n := 0; n < 8; n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			if got := fd("abc").(*image.RGBA).Pix; !bytes.Equal(got, want) {
+				t.Error("shared drawer drew a different image concurrently")
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+}
+
+func TestAnimation(t *testing.T) {
+	// three 10px wide bars, 10px apart
+	fd := func(string) draw.Image {
+		img := image.NewRGBA(image.Rect(0, 0, 60, 20))
+		draw.Draw(img, img.Bounds(), &image.Uniform{color.RGBA{192, 192, 192, 255}}, image.ZP, draw.Src)
+		for _, x := range []int{5, 25, 45} {
+			draw.Draw(img, image.Rect(x, 0, x+10, 20), &image.Uniform{color.Black}, image.ZP, draw.Src)
+		}
+		return img
+	}
+	ctx := context.WithValue(context.Background(), Rand, rand.New(rand.NewSource(1)))
+	ctx = context.WithValue(ctx, Animate, AnimateReveal)
+	ctx = context.WithValue(ctx, AnimationFrames, 2)
+	anim := GenAnimation(ctx, "", fd, RandomLines)
+	// 1 and 2 bars, then all three bars twice
+	if len(anim.Frames) != 4 {
+		t.Fatalf("expected 4 frames, got %d", len(anim.Frames))
+	}
+	for x := 25; x < 35; x++ {
+		if c := anim.Frames[0].At(x, 10); c == (color.RGBA{0, 0, 0, 255}) {
+			t.Fatal("second bar shown in the first frame")
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := anim.EncodeGIF(&buf); err != nil {
+		t.Fatal(err)
+	}
+	g, err := gif.DecodeAll(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(g.Image) != 4 || g.Delay[0] != 20 {
+		t.Errorf("expected 4 GIF frames 20/100s apart, got %d, %v", len(g.Image), g.Delay)
+	}
+
+	buf.Reset()
+	if err := anim.EncodeAPNG(&buf); err != nil {
+		t.Fatal(err)
+	}
+	chunks, err := pngChunks(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	count := map[string]int{}
+	for _, c := range chunks {
+		count[c.typ]++
+	}
+	if count["acTL"] != 1 || count["fcTL"] != 4 || count["IDAT"] < 1 || count["fdAT"] < 3 {
+		t.Errorf("unexpected APNG chunks %v", count)
+	}
+	// viewers without APNG support show the first frame
+	first, err := png.Decode(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !equalImages(first, anim.Frames[0]) {
+		t.Error("APNG default image differs from the first frame")
+	}
+}
+
+func TestEncodeWebP(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	noisy := image.NewNRGBA(image.Rect(0, 0, 37, 23))
+	rnd.Read(noisy.Pix)
+	ctx := context.WithValue(context.Background(), Rand, rnd)
+	for name, img := range map[string]image.Image{
+		"captcha": GenContext(ctx, "webp", Inconsolata(ctx), RandomLines(ctx)),
+		"noise":   noisy,
+		"pixel":   &image.NRGBA{Pix: []uint8{1, 2, 3, 4}, Stride: 4, Rect: image.Rect(0, 0, 1, 1)},
+		"flat":    image.NewGray(image.Rect(0, 0, 3, 2)),
+	} {
+		var buf bytes.Buffer
+		if err := EncodeWebP(&buf, img); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		got, err := webp.Decode(&buf)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		b := img.Bounds()
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				want := color.NRGBAModel.Convert(img.At(x, y))
+				if c := color.NRGBAModel.Convert(got.At(x-b.Min.X, y-b.Min.Y)); c != want {
+					t.Fatalf("%s: pixel %d,%d is %v, expected %v", name, x, y, c, want)
+				}
+			}
+		}
+	}
+}
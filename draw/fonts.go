@@ -4,20 +4,13 @@ import (
 	"bytes"
 	"context"
 	"encoding/base64"
-	"fmt"
 	"image"
 	"image/color"
 	"image/draw"
 	"log"
-	"math/rand"
-	"strings"
 	"sync"
-)
 
-var (
-	inconsolataCursor map[rune]*image.Rectangle
-	inconsolataFonts  map[rune]draw.Image
-	inconsolataSrc    image.Image
+	"golang.org/x/text/unicode/norm"
 )
 
 type FontVariant uint8
@@ -42,67 +35,53 @@ func (v FontVariant) path() string {
 	}[v]
 }
 
-var lastVariant FontVariant
+const (
+	inconsolataChars   = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789`~!@#$%^&*(){}[]'\"<>,./=¿?+-_\\|;:‘’“”¡¢£€¥Š§š×÷‹›→←↑↓ÀÁÂÃÄÅÆÇÈÉÊËÌÍÎÏÐÑÒÓÔÕÖØÙÚÛÜÝÞßàáâãäåæçèéêëìíîïðñòóôõöøùúûüýþÿĄĽŚŞŤŹŻŔĂĹĆČĘĚĎŃŇŐŘŮűłąăčďęľĺńňőřśşůŜĸŋΑαΒβΔδΕεΦφΓγΗηΙιΘθΚκΛλΜμΝνΟοΠπΧχΡρΣσΤτΥυΩωΞξΨψΖζ "
+	xOffset            = 24
+	yOffset            = 50
+)
 
-// Inconsolata draws text on img using the inconsolata font. ctx checks
-//     - InconsolataVariant under FontVariantCtxKey key
-func Inconsolata(ctx context.Context) func(string) draw.Image {
-	const (
-		chars   = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789`~!@#$%^&*(){}[]'\"<>,./=¿?+-_\\|;:‘’“”¡¢£€¥Š§š×÷‹›→←↑↓ÀÁÂÃÄÅÆÇÈÉÊËÌÍÎÏÐÑÒÓÔÕÖØÙÚÛÜÝÞßàáâãäåæçèéêëìíîïðñòóôõöøùúûüýþÿĄĽŚŞŤŹŻŔĂĹĆČĘĚĎŃŇŐŘŮűłąăčďęľĺńňőřśşůŜĸŋΑαΒβΔδΕεΦφΓγΗηΙιΘθΚκΛλΜμΝνΟοΠπΧχΡρΣσΤτΥυΩωΞξΨψΖζ "
-		xOffset = 24
-		yOffset = 50
-	)
-	var variant = VariantInconsolataGray
-	if v, ok := ctx.Value(FontVariantCtxKey).(FontVariant); ok {
-		variant = v
-	}
-	var scale = 1.0
-	if v, ok := ctx.Value(ScaleBy).(float64); ok {
-		scale = v
-	}
-	var randRot = true
-	var rotation = 0
-	if v, ok := ctx.Value(RandomRotation).(bool); ok {
-		randRot = v
-	}
-	if v, ok := ctx.Value(RotateBy).(int); ok {
-		rotation = v
-	}
-	var lineBreak = 18
-	if v, ok := ctx.Value(LineBreak).(int); ok {
-		lineBreak = v
-	}
-	if inconsolataSrc == nil || lastVariant != variant {
-		b64data := b64assets[variant.path()]
-		b, err := base64.StdEncoding.DecodeString(b64data)
-		// asset, err := Asset(variant.path())
-		// if err != nil {
-		// 	panic(err)
-		// }
-		fmt.Println(b64data)
-		srcFH := bytes.NewReader(b)
-		inconsolataSrc, _, err = image.Decode(srcFH)
+// inconsolataAtlas the glyphs of a variant, cut from its sprite. It is never
+// modified after loadInconsolata returns it, so it is shared by all the
+// FontDrawers of the variant.
+type inconsolataAtlas struct {
+	src    image.Image
+	cursor map[rune]image.Rectangle
+	glyphs map[rune]draw.Image
+}
+
+var inconsolataAtlases [VariantInconsolataInverted + 1]struct {
+	once  sync.Once
+	atlas *inconsolataAtlas
+}
+
+// loadInconsolata returns the atlas of variant, decoding its sprite on first
+// use.
+func loadInconsolata(variant FontVariant) *inconsolataAtlas {
+	cached := &inconsolataAtlases[variant]
+	cached.once.Do(func() {
+		b, err := base64.StdEncoding.DecodeString(b64assets[variant.path()])
 		if err != nil {
 			panic(err)
 		}
+		src, _, err := image.Decode(bytes.NewReader(b))
+		if err != nil {
+			panic(err)
+		}
+		atlas := &inconsolataAtlas{
+			src:    src,
+			cursor: make(map[rune]image.Rectangle),
+			glyphs: make(map[rune]draw.Image),
+		}
 		var x, y int
-		inconsolataCursor = make(map[rune]*image.Rectangle)
-		inconsolataFonts = make(map[rune]draw.Image)
-		for _, r := range chars {
+		for _, r := range inconsolataChars {
 			sr := image.Rectangle{image.Point{x, y}, image.Point{x + xOffset, y + yOffset}}
-			inconsolataCursor[r] = &sr
-			fontImg := image.NewRGBA(
-				image.Rectangle{
-					image.Point{0, 0},
-					image.Point{
-						sr.Bounds().Max.X - sr.Bounds().Min.X,
-						sr.Bounds().Max.Y - sr.Bounds().Min.Y,
-					},
-				})
+			atlas.cursor[r] = sr
+			fontImg := image.NewRGBA(image.Rect(0, 0, sr.Dx(), sr.Dy()))
 			gray := color.RGBA{192, 192, 192, 255}
 			draw.Draw(fontImg, fontImg.Bounds(), &image.Uniform{gray}, image.ZP, draw.Src)
-			draw.Draw(fontImg, fontImg.Bounds(), inconsolataSrc, sr.Min, draw.Src)
-			inconsolataFonts[r] = fontImg
+			draw.Draw(fontImg, fontImg.Bounds(), src, sr.Min, draw.Src)
+			atlas.glyphs[r] = fontImg
 			if r == 'z' ||
 				r == 'Z' ||
 				r == ']' ||
@@ -119,10 +98,39 @@ func Inconsolata(ctx context.Context) func(string) draw.Image {
 				x += xOffset
 			}
 		}
+		cached.atlas = atlas
+	})
+	return cached.atlas
+}
+
+// Inconsolata draws text on img using the inconsolata font. ctx checks
+//     - InconsolataVariant under FontVariantCtxKey key
+func Inconsolata(ctx context.Context) func(string) draw.Image {
+	var variant = VariantInconsolataGray
+	if v, ok := ctx.Value(FontVariantCtxKey).(FontVariant); ok {
+		variant = v
+	}
+	var scale = 1.0
+	if v, ok := ctx.Value(ScaleBy).(float64); ok {
+		scale = v
+	}
+	var randRot = true
+	var rotation = 0
+	if v, ok := ctx.Value(RandomRotation).(bool); ok {
+		randRot = v
 	}
+	if v, ok := ctx.Value(RotateBy).(int); ok {
+		rotation = v
+	}
+	var lineBreak = 18
+	if v, ok := ctx.Value(LineBreak).(int); ok {
+		lineBreak = v
+	}
+	rnd := rng(ctx)
+	atlas := loadInconsolata(variant)
 
 	return func(text string) draw.Image {
-		lines, longest := breakLines(text, lineBreak)
+		lines, longest := layout(text, lineBreak)
 		var wide = 1.0
 		if !randRot && (rotation >= 55 && rotation < 125) || (rotation >= 235 && rotation < 305) {
 			wide = 1.3
@@ -139,95 +147,65 @@ func Inconsolata(ctx context.Context) func(string) draw.Image {
 		var c color.RGBA
 		switch variant {
 		case VariantInconsolataBlack:
-			ctx = context.WithValue(ctx, BackgroundColor, color.RGBA{255, 255, 255, 255})
+			c = color.RGBA{255, 255, 255, 255}
 		case VariantInconsolataInverted:
-			ctx = context.WithValue(ctx, BackgroundColor, color.RGBA{0, 0, 0, 255})
+			c = color.RGBA{0, 0, 0, 255}
 		default:
-			ctx = context.WithValue(ctx, BackgroundColor, color.RGBA{192, 192, 192, 255})
+			c = color.RGBA{192, 192, 192, 255}
 		}
+		// the drawer may be shared, it must not write to ctx
+		dctx := context.WithValue(ctx, BackgroundColor, c)
 		draw.Draw(img, img.Bounds(), &image.Uniform{c}, image.ZP, draw.Src)
 
-		var wg sync.WaitGroup
+		// lines are drawn in order, so that rotations are picked in the same
+		// order for the same Rand
 		xpos, ypos := 0, 0
 		for _, line := range lines {
-			// get font positions
-			wg.Add(1)
-			go func(line string, xpos, ypos int) {
-				defer wg.Done()
-				for _, run := range line {
-					if glyph, ok := inconsolataFonts[run]; ok {
-						if randRot {
-							// -35 to 35 deg
-							rotation = rand.Intn(75) - 35
-						}
-						scaled := Scale(ctx, glyph, scale)
-						rotated := Rotate(ctx, scaled, rotation)
-						// rotated := Rotate(scaled, rotation)
-						r := image.Rectangle{
-							image.Pt(xpos, ypos),
-							image.Pt(xpos, ypos).Add(rotated.Bounds().Size()),
-						}
-						draw.Draw(
-							img,
-							r,
-							rotated,
-							rotated.Bounds().Min,
-							draw.Over)
-						xpos += rotated.Bounds().Dx()
-						continue
-					} else if sr, ok := inconsolataCursor[run]; ok {
-						r := image.Rectangle{
-							image.Pt(xpos, ypos),
-							image.Pt(xpos, ypos).Add(sr.Size()),
-						}
-						// fontImg := image.NewRGBA(r)
-						// // gray := color.RGBA{192, 192, 192, 255}
-						// // draw.Draw(fontImg, fontImg.Bounds(), &image.Uniform{gray}, image.ZP, draw.Src)
-						// // draw.Draw(fontImg, r, inconsolataSrc, sr.Min, draw.Src)
-						// fimg := Scale(fontImg, 2.0)
-						// draw.Draw(img, r, fimg, sr.Min, draw.Src)
-						draw.Draw(img, r, inconsolataSrc, sr.Min, draw.Src)
-						xpos += int(float64(xOffset) * scale)
-						continue
+			for _, g := range line {
+				// the sprite only has precomposed characters
+				run := []rune(norm.NFC.String(g))[0]
+				if glyph, ok := atlas.glyphs[run]; ok {
+					deg := rotation
+					if randRot {
+						// -35 to 35 deg
+						deg = rnd.Intn(75) - 35
+					}
+					scaled := Scale(dctx, glyph, scale)
+					rotated := Rotate(dctx, scaled, deg)
+					// rotated := Rotate(scaled, rotation)
+					r := image.Rectangle{
+						image.Pt(xpos, ypos),
+						image.Pt(xpos, ypos).Add(rotated.Bounds().Size()),
+					}
+					draw.Draw(
+						img,
+						r,
+						rotated,
+						rotated.Bounds().Min,
+						draw.Over)
+					xpos += rotated.Bounds().Dx()
+					continue
+				} else if sr, ok := atlas.cursor[run]; ok {
+					r := image.Rectangle{
+						image.Pt(xpos, ypos),
+						image.Pt(xpos, ypos).Add(sr.Size()),
 					}
-					log.Printf("unknown character %c (%[1]U)", run)
+					// fontImg := image.NewRGBA(r)
+					// // gray := color.RGBA{192, 192, 192, 255}
+					// // draw.Draw(fontImg, fontImg.Bounds(), &image.Uniform{gray}, image.ZP, draw.Src)
+					// // draw.Draw(fontImg, r, inconsolataSrc, sr.Min, draw.Src)
+					// fimg := Scale(fontImg, 2.0)
+					// draw.Draw(img, r, fimg, sr.Min, draw.Src)
+					draw.Draw(img, r, atlas.src, sr.Min, draw.Src)
+					xpos += int(float64(xOffset) * scale)
+					continue
 				}
-			}(line, xpos, ypos)
+				log.Printf("unknown character %c (%[1]U)", run)
+			}
 			ypos += yOffset
 			xpos = 0
 		}
-		wg.Wait()
 		return img
 	}
 
 }
-
-// breakLines breaks text into lines, at the first space at or after
-// count characters.
-func breakLines(text string, count int) (lines []string, longest int) {
-	text = strings.ReplaceAll(text, "\t", "  ")
-	lines = make([]string, 0)
-	i, j := 0, 1
-	for j < len(text) {
-		if (text[j] == ' ' && len(text[i:j]) > count) || text[j] == '\n' {
-			part := strings.TrimSpace(text[i:j])
-			lines = append(lines, part)
-			if len(part) > longest {
-				longest = len(part)
-			}
-			i = j
-			// account for the last bit
-			if len(text[i:]) < count {
-				lines = append(lines, strings.TrimSpace(text[i:]))
-			}
-		}
-		j++
-	}
-	// if there are no linebreaks, set the longest
-	// to the length of the text
-	if len(lines) <= 1 {
-		lines = []string{text}
-		longest = len(text)
-	}
-	return
-}
@@ -0,0 +1,82 @@
+package draw
+
+import (
+	"math"
+	"math/rand"
+)
+
+// perlin is a 2D gradient noise generator, see
+// https://mrl.cs.nyu.edu/~perlin/noise/
+type perlin struct {
+	perm [512]uint8
+}
+
+func newPerlin(r *rand.Rand) *perlin {
+	p := &perlin{}
+	for i, v := range r.Perm(256) {
+		p.perm[i] = uint8(v)
+		p.perm[i+256] = uint8(v)
+	}
+	return p
+}
+
+// at returns the noise at x, y, in the range [-1, 1]. It varies smoothly,
+// with features about 1 unit wide.
+func (p *perlin) at(x, y float64) float64 {
+	xf, yf := math.Floor(x), math.Floor(y)
+	xi, yi := int(xf)&255, int(yf)&255
+	x, y = x-xf, y-yf
+	u, v := fade(x), fade(y)
+
+	aa := p.perm[int(p.perm[xi])+yi]
+	ab := p.perm[int(p.perm[xi])+yi+1]
+	ba := p.perm[int(p.perm[xi+1])+yi]
+	bb := p.perm[int(p.perm[xi+1])+yi+1]
+
+	return lerp(v,
+		lerp(u, grad(aa, x, y), grad(ba, x-1, y)),
+		lerp(u, grad(ab, x, y-1), grad(bb, x-1, y-1)),
+	) * math.Sqrt2
+}
+
+// octaves sums n octaves of noise at x, y, each twice the frequency and half
+// the amplitude of the previous, normalized to [-1, 1].
+func (p *perlin) octaves(x, y float64, n int) float64 {
+	var sum, amp, total float64 = 0, 1, 0
+	for i := 0; i < n; i++ {
+		sum += p.at(x, y) * amp
+		total += amp
+		x, y, amp = x*2, y*2, amp/2
+	}
+	return sum / total
+}
+
+func fade(t float64) float64 {
+	return t * t * t * (t*(t*6-15) + 10)
+}
+
+func lerp(t, a, b float64) float64 {
+	return a + t*(b-a)
+}
+
+// grad is the dot product of x, y with one of 8 gradients picked by hash.
+func grad(hash uint8, x, y float64) float64 {
+	switch hash & 7 {
+	case 0:
+		return x + y
+	case 1:
+		return -x + y
+	case 2:
+		return x - y
+	case 3:
+		return -x - y
+	case 4:
+		return x
+	case 5:
+		return -x
+	case 6:
+		return y
+	default:
+		return -y
+	}
+}
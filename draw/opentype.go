@@ -0,0 +1,238 @@
+package draw
+
+import (
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"io/ioutil"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/font/sfnt"
+	"golang.org/x/image/math/fixed"
+)
+
+// Font is a parsed TrueType or OpenType font, safe for concurrent use.
+type Font struct {
+	f *opentype.Font
+}
+
+// ParseFont parses a TTF or OTF font from data, e.g. one embedded in the
+// binary.
+func ParseFont(data []byte) (*Font, error) {
+	f, err := opentype.Parse(data)
+	if err != nil {
+		return nil, err
+	}
+	return &Font{f: f}, nil
+}
+
+// LoadFont reads and parses the TTF or OTF font file at path.
+func LoadFont(path string) (*Font, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseFont(data)
+}
+
+// has reports whether f has glyphs for all the runes of g.
+func (f *Font) has(g string) bool {
+	var buf sfnt.Buffer
+	for _, r := range g {
+		if i, err := f.f.GlyphIndex(&buf, r); err != nil || i == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// OpenType draws text with one of fonts, picked at random for each text.
+// Characters missing from the picked font are drawn with the first of fonts
+// that has them. Right to left text is laid out in visual order, and arabic
+// letters are joined, but there is no further shaping: scripts that need it,
+// e.g. devanagari, are drawn one character at a time. ctx checks:
+//   - FontSize size in points (float64), defaults to 32
+//   - FontColor color of the glyphs (image/color.RGBA)
+//   - BackgroundColor
+//   - LineBreak
+//   - ScaleBy
+//   - RotateBy and RandomRotation
+//   - GlyphJitter
+//
+// Returns an error if no fonts are passed, or if they cannot be opened at the
+// requested size.
+func OpenType(ctx context.Context, fonts ...*Font) (FontDrawer, error) {
+	if len(fonts) == 0 {
+		return nil, errors.New("draw: OpenType needs at least one font")
+	}
+	var size = 32.0
+	if v, ok := ctx.Value(FontSize).(float64); ok {
+		size = v
+	}
+	if size <= 0 {
+		return nil, errors.New("draw: font size must be positive")
+	}
+	opts := &opentype.FaceOptions{
+		Size:    size,
+		DPI:     72,
+		Hinting: font.HintingFull,
+	}
+	for _, f := range fonts {
+		face, err := opentype.NewFace(f.f, opts)
+		if err != nil {
+			return nil, err
+		}
+		face.Close()
+	}
+	var fg = color.RGBA{64, 64, 64, 255}
+	if v, ok := ctx.Value(FontColor).(color.RGBA); ok {
+		fg = v
+	}
+	var bg = color.RGBA{192, 192, 192, 255}
+	if v, ok := ctx.Value(BackgroundColor).(color.RGBA); ok {
+		bg = v
+	}
+	var scale = 1.0
+	if v, ok := ctx.Value(ScaleBy).(float64); ok {
+		scale = v
+	}
+	var randRot = true
+	var rotation = 0
+	if v, ok := ctx.Value(RandomRotation).(bool); ok {
+		randRot = v
+	}
+	if v, ok := ctx.Value(RotateBy).(int); ok {
+		rotation = v
+	}
+	var lineBreak = 18
+	if v, ok := ctx.Value(LineBreak).(int); ok {
+		lineBreak = v
+	}
+	var jitter = 0
+	if v, ok := ctx.Value(GlyphJitter).(int); ok {
+		jitter = v
+	}
+	ctx = context.WithValue(ctx, BackgroundColor, bg)
+	rnd := rng(ctx)
+
+	return func(text string) draw.Image {
+		faces := make([]font.Face, len(fonts))
+		pick := rnd.Intn(len(fonts))
+		// faces are not safe for concurrent use, open them for each text
+		face := func(i int) font.Face {
+			if faces[i] == nil {
+				var err error
+				if faces[i], err = opentype.NewFace(fonts[i].f, opts); err != nil {
+					// the same options succeeded above
+					panic(err)
+				}
+			}
+			return faces[i]
+		}
+		defer func() {
+			for _, f := range faces {
+				if f != nil {
+					f.Close()
+				}
+			}
+		}()
+
+		type placed struct {
+			img        draw.Image
+			x, y, line int
+		}
+		var (
+			glyphs        []placed
+			width, height int
+		)
+		lines, _ := layout(text, lineBreak)
+		lineWidths := make([]int, len(lines))
+		for n, line := range lines {
+			var xpos, lineHeight int
+			for _, g := range line {
+				i := pick
+				if !fonts[i].has(g) {
+					for j, f := range fonts {
+						if f.has(g) {
+							i = j
+							break
+						}
+					}
+				}
+				glyph := drawGlyph(face(i), g, fg, bg)
+				deg := rotation
+				if randRot {
+					// -35 to 35 deg
+					deg = rnd.Intn(75) - 35
+				}
+				rotated := Rotate(ctx, Scale(ctx, glyph, scale), deg)
+				x, dy := xpos, 0
+				if jitter > 0 {
+					x += rnd.Intn(2*jitter+1) - jitter
+					dy = rnd.Intn(2*jitter + 1)
+					if x < 0 {
+						x = 0
+					}
+				}
+				glyphs = append(glyphs, placed{rotated, x, height + dy, n})
+				xpos += rotated.Bounds().Dx()
+				if right := x + rotated.Bounds().Dx(); right > lineWidths[n] {
+					lineWidths[n] = right
+				}
+				if h := rotated.Bounds().Dy() + dy; h > lineHeight {
+					lineHeight = h
+				}
+			}
+			if lineWidths[n] > width {
+				width = lineWidths[n]
+			}
+			height += lineHeight
+		}
+		rtl := isRTL(text)
+
+		img := image.NewRGBA(image.Rect(0, 0, width, height))
+		draw.Draw(img, img.Bounds(), &image.Uniform{bg}, image.ZP, draw.Src)
+		for _, g := range glyphs {
+			if rtl {
+				// right to left text is aligned to the right
+				g.x += width - lineWidths[g.line]
+			}
+			r := image.Rectangle{
+				image.Pt(g.x, g.y),
+				image.Pt(g.x, g.y).Add(g.img.Bounds().Size()),
+			}
+			draw.Draw(img, r, g.img, g.img.Bounds().Min, draw.Over)
+		}
+		return img
+	}, nil
+}
+
+// drawGlyph returns an image of the grapheme g drawn with face in fg over
+// bg, as wide as its advance and as tall as the face's line height.
+func drawGlyph(face font.Face, g string, fg, bg color.RGBA) draw.Image {
+	metrics := face.Metrics()
+	advance := font.MeasureString(face, g)
+	bounds, _ := font.BoundString(face, g)
+	// glyphs may overhang their advance, e.g. italics
+	left := fixed.Int26_6(0)
+	if bounds.Min.X < 0 {
+		left = -bounds.Min.X
+	}
+	w := (left + advance).Ceil()
+	if right := (left + bounds.Max.X).Ceil(); right > w {
+		w = right
+	}
+	img := image.NewRGBA(image.Rect(0, 0, w, (metrics.Ascent + metrics.Descent).Ceil()))
+	draw.Draw(img, img.Bounds(), &image.Uniform{bg}, image.ZP, draw.Src)
+	d := &font.Drawer{
+		Dst:  img,
+		Src:  &image.Uniform{fg},
+		Face: face,
+		Dot:  fixed.Point26_6{X: left, Y: metrics.Ascent},
+	}
+	d.DrawString(g)
+	return img
+}
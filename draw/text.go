@@ -0,0 +1,323 @@
+package draw
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/bidi"
+)
+
+// graphemes splits s into user-perceived characters: a base rune followed
+// by its combining marks, and runes joined by a zero width joiner.
+func graphemes(s string) []string {
+	const zwj = '‍'
+	var (
+		out    []string
+		cur    []rune
+		joined bool
+	)
+	for _, r := range s {
+		if len(cur) > 0 && !joined && !unicode.Is(unicode.M, r) && r != zwj {
+			out = append(out, string(cur))
+			cur = cur[:0]
+		}
+		cur = append(cur, r)
+		joined = r == zwj
+	}
+	if len(cur) > 0 {
+		out = append(out, string(cur))
+	}
+	return out
+}
+
+// breakLines breaks text into lines, at the first space after more than
+// count characters, and at newlines. Characters are counted as graphemes.
+// longest is the length of the longest line.
+func breakLines(text string, count int) (lines []string, longest int) {
+	text = strings.ReplaceAll(text, "\t", "  ")
+	for _, para := range strings.Split(text, "\n") {
+		var line []string
+		n := 0
+		for _, word := range strings.Split(para, " ") {
+			line = append(line, word)
+			n += len(graphemes(word)) + 1
+			if n-1 > count {
+				lines = append(lines, strings.TrimSpace(strings.Join(line, " ")))
+				line, n = nil, 0
+			}
+		}
+		if len(line) > 0 || len(lines) == 0 {
+			lines = append(lines, strings.TrimSpace(strings.Join(line, " ")))
+		}
+	}
+	for _, l := range lines {
+		if n := len(graphemes(l)); n > longest {
+			longest = n
+		}
+	}
+	return
+}
+
+// layout breaks text into lines as breakLines does, and returns the
+// graphemes of each line in the order they are drawn from left to right.
+// Arabic letters are replaced by their contextual forms.
+func layout(text string, count int) (lines [][]string, longest int) {
+	ll, longest := breakLines(text, count)
+	rtl := isRTL(text)
+	for _, l := range ll {
+		lines = append(lines, visualOrder(shapeArabic(graphemes(l)), rtl))
+	}
+	return
+}
+
+// class returns the bidi class of the first rune of g.
+func class(g string) bidi.Class {
+	for _, r := range g {
+		p, _ := bidi.LookupRune(r)
+		return p.Class()
+	}
+	return bidi.ON
+}
+
+// isRTL reports whether the first strong character of text is right to left.
+func isRTL(text string) bool {
+	for _, r := range text {
+		p, _ := bidi.LookupRune(r)
+		switch p.Class() {
+		case bidi.L:
+			return false
+		case bidi.R, bidi.AL:
+			return true
+		}
+	}
+	return false
+}
+
+// visualOrder reorders the graphemes of a line from logical to visual order,
+// following a simplified unicode bidirectional algorithm: explicit embeddings
+// are ignored, and numbers are kept left to right. Brackets in right to left
+// runs are mirrored.
+func visualOrder(gs []string, rtl bool) []string {
+	base := bidi.L
+	var baseLevel int
+	if rtl {
+		base, baseLevel = bidi.R, 1
+	}
+	types := make([]bidi.Class, len(gs))
+	for i, g := range gs {
+		types[i] = class(g)
+	}
+
+	// weak types
+	strong := base
+	for i, t := range types {
+		switch t {
+		case bidi.L, bidi.R:
+			strong = t
+		case bidi.AL:
+			strong = t
+			types[i] = bidi.R
+		case bidi.EN:
+			switch strong {
+			case bidi.AL:
+				types[i] = bidi.AN
+			case bidi.L:
+				types[i] = bidi.L
+			}
+		}
+	}
+	for i := 1; i+1 < len(types); i++ {
+		// separators between numbers
+		if (types[i] == bidi.ES || types[i] == bidi.CS) && types[i-1] == types[i+1] &&
+			(types[i-1] == bidi.EN || types[i-1] == bidi.AN) {
+			types[i] = types[i-1]
+		}
+	}
+
+	// neutrals take the direction of the strong types around them if they
+	// agree, the base direction otherwise
+	dir := func(t bidi.Class) (bidi.Class, bool) {
+		switch t {
+		case bidi.L:
+			return bidi.L, true
+		case bidi.R, bidi.EN, bidi.AN:
+			return bidi.R, true
+		}
+		return 0, false
+	}
+	for i := 0; i < len(types); {
+		if _, ok := dir(types[i]); ok {
+			i++
+			continue
+		}
+		j := i
+		for j < len(types) {
+			if _, ok := dir(types[j]); ok {
+				break
+			}
+			j++
+		}
+		before, after := base, base
+		if i > 0 {
+			before, _ = dir(types[i-1])
+		}
+		if j < len(types) {
+			after, _ = dir(types[j])
+		}
+		resolved := base
+		if before == after {
+			resolved = before
+		}
+		for k := i; k < j; k++ {
+			types[k] = resolved
+		}
+		i = j
+	}
+
+	// implicit levels
+	levels := make([]int, len(types))
+	highest := 0
+	for i, t := range types {
+		lvl := baseLevel
+		switch {
+		case baseLevel%2 == 0 && t == bidi.R:
+			lvl++
+		case baseLevel%2 == 0 && (t == bidi.EN || t == bidi.AN):
+			lvl += 2
+		case baseLevel%2 == 1 && (t == bidi.L || t == bidi.EN || t == bidi.AN):
+			lvl++
+		}
+		levels[i] = lvl
+		if lvl > highest {
+			highest = lvl
+		}
+	}
+
+	out := make([]string, len(gs))
+	for i, g := range gs {
+		if levels[i]%2 == 1 {
+			g = mirror(g)
+		}
+		out[i] = g
+	}
+	// reverse every run at or above each odd level, from the highest down
+	for lvl := highest; lvl >= 1; lvl-- {
+		for i := 0; i < len(out); {
+			if levels[i] < lvl {
+				i++
+				continue
+			}
+			j := i
+			for j < len(out) && levels[j] >= lvl {
+				j++
+			}
+			for a, b := i, j-1; a < b; a, b = a+1, b-1 {
+				out[a], out[b] = out[b], out[a]
+				levels[a], levels[b] = levels[b], levels[a]
+			}
+			i = j
+		}
+	}
+	return out
+}
+
+var mirrored = map[rune]rune{
+	'(': ')', ')': '(',
+	'[': ']', ']': '[',
+	'{': '}', '}': '{',
+	'<': '>', '>': '<',
+	'«': '»', '»': '«',
+}
+
+func mirror(g string) string {
+	r := []rune(g)
+	if m, ok := mirrored[r[0]]; ok {
+		r[0] = m
+		return string(r)
+	}
+	return g
+}
+
+// arabicForms first presentation form (isolated) of the arabic letters, the
+// final, initial and medial forms follow it. dual is false for the letters
+// that only join the preceding letter.
+var arabicForms = map[rune]struct {
+	isolated rune
+	dual     bool
+}{
+	'ء': {'ﺀ', false}, 'آ': {'ﺁ', false}, 'أ': {'ﺃ', false},
+	'ؤ': {'ﺅ', false}, 'إ': {'ﺇ', false}, 'ئ': {'ﺉ', true},
+	'ا': {'ﺍ', false}, 'ب': {'ﺏ', true}, 'ة': {'ﺓ', false},
+	'ت': {'ﺕ', true}, 'ث': {'ﺙ', true}, 'ج': {'ﺝ', true},
+	'ح': {'ﺡ', true}, 'خ': {'ﺥ', true}, 'د': {'ﺩ', false},
+	'ذ': {'ﺫ', false}, 'ر': {'ﺭ', false}, 'ز': {'ﺯ', false},
+	'س': {'ﺱ', true}, 'ش': {'ﺵ', true}, 'ص': {'ﺹ', true},
+	'ض': {'ﺽ', true}, 'ط': {'ﻁ', true}, 'ظ': {'ﻅ', true},
+	'ع': {'ﻉ', true}, 'غ': {'ﻍ', true}, 'ف': {'ﻑ', true},
+	'ق': {'ﻕ', true}, 'ك': {'ﻙ', true}, 'ل': {'ﻝ', true},
+	'م': {'ﻡ', true}, 'ن': {'ﻥ', true}, 'ه': {'ﻩ', true},
+	'و': {'ﻭ', false}, 'ى': {'ﻯ', false}, 'ي': {'ﻱ', true},
+}
+
+// lamAlef isolated forms of the lam-alef ligatures, by alef.
+var lamAlef = map[rune]rune{
+	'آ': 'ﻵ',
+	'أ': 'ﻷ',
+	'إ': 'ﻹ',
+	'ا': 'ﻻ',
+}
+
+// shapeArabic replaces the arabic letters of gs, in logical order, with
+// their contextual presentation forms, and lam followed by alef with their
+// ligature.
+func shapeArabic(gs []string) []string {
+	base := func(i int) rune {
+		if i < 0 || i >= len(gs) {
+			return 0
+		}
+		return []rune(gs[i])[0]
+	}
+	const tatweel = 'ـ'
+	joinsNext := func(i int) bool {
+		r := base(i)
+		return r == tatweel || arabicForms[r].dual
+	}
+	joinsPrev := func(i int) bool {
+		r := base(i)
+		_, ok := arabicForms[r]
+		return (ok && r != 'ء') || r == tatweel
+	}
+	out := make([]string, 0, len(gs))
+	for i := 0; i < len(gs); i++ {
+		r := []rune(gs[i])
+		f, ok := arabicForms[r[0]]
+		if !ok {
+			out = append(out, gs[i])
+			continue
+		}
+		prev := i > 0 && joinsNext(i-1) && r[0] != 'ء'
+		if lig, ok := lamAlef[base(i+1)]; ok && r[0] == 'ل' {
+			if prev {
+				lig++
+			}
+			// keep the marks of both letters
+			marks := string(r[1:]) + string([]rune(gs[i+1])[1:])
+			out = append(out, string(lig)+marks)
+			i++
+			continue
+		}
+		next := f.dual && joinsPrev(i+1)
+		form := f.isolated
+		switch {
+		case prev && next:
+			form += 3
+		case next:
+			form += 2
+		case prev:
+			form++
+		}
+		r[0] = form
+		out = append(out, string(r))
+	}
+	return out
+}
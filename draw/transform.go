@@ -7,19 +7,15 @@ import (
 	"image/draw"
 	"math"
 
-	"gonum.org/v1/gonum/mat"
+	xdraw "golang.org/x/image/draw"
 )
 
+// Rotate rotates img deg degrees counterclockwise about its center, onto a
+// canvas centered at the origin, filled with the BackgroundColor in ctx.
 func Rotate(ctx context.Context, img draw.Image, deg int) draw.Image {
 	if deg%360 == 0 {
 		return img
 	}
-	rotM := mat.NewDense(2, 2, []float64{
-		math.Cos(degToRad(-deg)),
-		-math.Sin(degToRad(-deg)),
-		math.Sin(degToRad(-deg)),
-		math.Cos(degToRad(-deg)),
-	})
 
 	// size needs to be adjusted for 45 < deg < 135 and 225 < deg < 315
 	var wide = 1.0
@@ -27,40 +23,27 @@ func Rotate(ctx context.Context, img draw.Image, deg int) draw.Image {
 		wide = 1.3
 	}
 
+	b := img.Bounds()
 	canvas := image.NewRGBA(
 		image.Rectangle{
-			image.Point{int(math.Round(-float64(img.Bounds().Dx()) * wide / 2)), -img.Bounds().Dy() / 2},
-			image.Point{int(math.Round(float64(img.Bounds().Dx()) * wide / 2)), img.Bounds().Dy() / 2},
+			image.Point{int(math.Round(-float64(b.Dx()) * wide / 2)), -b.Dy() / 2},
+			image.Point{int(math.Round(float64(b.Dx()) * wide / 2)), b.Dy() / 2},
 		},
 	)
-	// canvas := image.NewRGBA(img.Bounds())
-	// draw.Draw(canvas, canvas.Bounds(), m, b.Min.Add(p), draw.Src)
-
-	var bgColor = color.RGBA{192, 192, 192, 255}
-	if v, ok := ctx.Value(BackgroundColor).(color.RGBA); ok {
-		bgColor = v
-	}
 
-	draw.Draw(canvas, canvas.Bounds(), &image.Uniform{bgColor}, image.ZP, draw.Src)
-	for x := 0; x < img.Bounds().Dx(); x++ {
-		for y := 0; y < img.Bounds().Dy(); y++ {
-			var pt mat.Dense
-
-			// when rotating, we offset both x and y by -img.height/2 and -img.width/2,
-			// and avoid unwanted translation
-			pt.Mul(
-				rotM,
-				mat.NewDense(
-					2,
-					1,
-					[]float64{float64(x - img.Bounds().Dx()/2), float64(y - img.Bounds().Dy()/2)}),
-			)
-			canvas.Set(int(pt.At(0, 0)), int(pt.At(1, 0)), img.At(x, y))
-		}
-	}
+	// every pixel of the canvas is rotated back onto img, offset by its
+	// center
+	sin, cos := math.Sincos(degToRad(deg))
+	cx, cy := float64(b.Min.X+b.Dx()/2), float64(b.Min.Y+b.Dy()/2)
+	affine(canvas, toRGBA(img), transformBackground(ctx), [6]float64{
+		cos, -sin, cx,
+		sin, cos, cy,
+	})
 	return canvas
 }
 
+// Scale scales img by c, onto a canvas filled with the BackgroundColor in
+// ctx.
 func Scale(ctx context.Context, img draw.Image, c float64) draw.Image {
 	if math.Abs(c-1.0) < 0.001 {
 		return img
@@ -68,10 +51,6 @@ func Scale(ctx context.Context, img draw.Image, c float64) draw.Image {
 	if c < 0.0 {
 		c = 0.0
 	}
-	var fillFactor int = 1
-	if c > 1.0 {
-		fillFactor = int(math.Round(c / 0.5))
-	}
 	scaled := image.NewRGBA(
 		image.Rect(
 			0,
@@ -80,29 +59,108 @@ func Scale(ctx context.Context, img draw.Image, c float64) draw.Image {
 			int(math.Round(float64(img.Bounds().Max.Y)*c)),
 		),
 	)
-	var bgColor = color.RGBA{192, 192, 192, 255}
+	if scaled.Rect.Empty() {
+		return scaled
+	}
+	// pixel centers of scaled map to pixel centers of img
+	affine(scaled, toRGBA(img), transformBackground(ctx), [6]float64{
+		1 / c, 0, 0.5/c - 0.5,
+		0, 1 / c, 0.5/c - 0.5,
+	})
+	return scaled
+}
+
+func transformBackground(ctx context.Context) color.RGBA {
 	if v, ok := ctx.Value(BackgroundColor).(color.RGBA); ok {
-		bgColor = v
+		return v
 	}
-	draw.Draw(scaled, scaled.Bounds(), &image.Uniform{bgColor}, image.ZP, draw.Src)
-	sc := mat.NewDiagDense(3, []float64{c, c, 1.0})
-	for x := 0; x < img.Bounds().Dx(); x++ {
-		for y := 0; y < img.Bounds().Dy(); y++ {
-			var pt mat.Dense
-			pt.Mul(sc, mat.NewDense(3, 1, []float64{float64(x), float64(y), 1.0}))
-			for i := -fillFactor; i < fillFactor; i++ {
-				scaled.Set(
-					int(math.Round(pt.At(0, 0)))+i,
-					int(math.Round(pt.At(1, 0)))+i,
-					img.At(x, y),
-				)
+	return color.RGBA{192, 192, 192, 255}
+}
+
+// toRGBA returns img itself if it already is an *image.RGBA, a copy
+// otherwise.
+func toRGBA(img image.Image) *image.RGBA {
+	if rgba, ok := img.(*image.RGBA); ok {
+		return rgba
+	}
+	return snapshot(img)
+}
+
+// affine sets every pixel x, y of dst to the pixel of src at (m[0]*x +
+// m[1]*y + m[2], m[3]*x + m[4]*y + m[5]), interpolated bilinearly. Pixels
+// from outside src are bg.
+func affine(dst, src *image.RGBA, bg color.RGBA, m [6]float64) {
+	b, sb := dst.Rect, src.Rect
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		// the source point moves by m[0], m[3] along the row
+		sx := m[0]*float64(b.Min.X) + m[1]*float64(y) + m[2]
+		sy := m[3]*float64(b.Min.X) + m[4]*float64(y) + m[5]
+		i := dst.PixOffset(b.Min.X, y)
+		for x := b.Min.X; x < b.Max.X; x, i, sx, sy = x+1, i+4, sx+m[0], sy+m[3] {
+			x0, y0 := math.Floor(sx), math.Floor(sy)
+			ix, iy := int(x0), int(y0)
+			if ix < sb.Min.X || iy < sb.Min.Y || ix+1 >= sb.Max.X || iy+1 >= sb.Max.Y {
+				// on or past the edges, let bilinear blend in bg
+				c := bg
+				if ix >= sb.Min.X-1 && iy >= sb.Min.Y-1 && ix < sb.Max.X && iy < sb.Max.Y {
+					c = bilinear(src, sx, sy, bg)
+				}
+				dst.Pix[i], dst.Pix[i+1], dst.Pix[i+2], dst.Pix[i+3] = c.R, c.G, c.B, c.A
+				continue
+			}
+			// 8 bit fixed point weights, adding up to 1<<16
+			fx, fy := uint32((sx-x0)*256+0.5), uint32((sy-y0)*256+0.5)
+			w00, w10 := (256-fx)*(256-fy), fx*(256-fy)
+			w01, w11 := (256-fx)*fy, fx*fy
+			j := src.PixOffset(ix, iy)
+			p0 := src.Pix[j : j+8 : j+8]
+			p1 := src.Pix[j+src.Stride : j+src.Stride+8 : j+src.Stride+8]
+			d := dst.Pix[i : i+4 : i+4]
+			for k := range d {
+				d[k] = uint8((uint32(p0[k])*w00 + uint32(p0[k+4])*w10 +
+					uint32(p1[k])*w01 + uint32(p1[k+4])*w11 + 1<<15) >> 16)
 			}
 		}
 	}
-
-	return scaled
 }
 
 func degToRad(deg int) float64 {
 	return float64(deg%360) * math.Pi / 180.0
 }
+
+// Fit scales img to fit in width x height, keeping its aspect ratio, and
+// centers it on an image of exactly that size, filled with the background
+// of img. If width or height are 0, they follow the aspect ratio of img.
+func Fit(img image.Image, width, height int) draw.Image {
+	b := img.Bounds()
+	switch {
+	case width <= 0 && height <= 0:
+		return snapshot(img)
+	case width <= 0:
+		width = int(math.Max(1, math.Round(float64(b.Dx())*float64(height)/float64(b.Dy()))))
+	case height <= 0:
+		height = int(math.Max(1, math.Round(float64(b.Dy())*float64(width)/float64(b.Dx()))))
+	}
+	c := math.Min(float64(width)/float64(b.Dx()), float64(height)/float64(b.Dy()))
+	return place(img, width, height, c)
+}
+
+// Shrink scales img by scale, 0.5 for half its size, and centers it on an
+// image of the size of img, filled with its background.
+func Shrink(img image.Image, scale float64) draw.Image {
+	b := img.Bounds()
+	return place(img, b.Dx(), b.Dy(), scale)
+}
+
+// place scales img by scale, and centers it on an image of width x height,
+// filled with the background of img.
+func place(img image.Image, width, height int, scale float64) draw.Image {
+	b := img.Bounds()
+	w := int(math.Max(1, math.Round(float64(b.Dx())*scale)))
+	h := int(math.Max(1, math.Round(float64(b.Dy())*scale)))
+	out := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(out, out.Bounds(), &image.Uniform{borderColor(img)}, image.ZP, draw.Src)
+	r := image.Rect(0, 0, w, h).Add(image.Pt((width-w)/2, (height-h)/2))
+	xdraw.CatmullRom.Scale(out, r, img, b, xdraw.Src, nil)
+	return out
+}
@@ -0,0 +1,179 @@
+package draw
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+)
+
+// SineWave displaces the pixels of img along sine waves, horizontally and
+// vertically, bending the glyphs. ctx reads WaveAmplitude, WavePeriod and
+// BackgroundColor keys.
+func SineWave(ctx context.Context) Fuzzer {
+	var amplitude = 4.0
+	if v, ok := ctx.Value(WaveAmplitude).(float64); ok {
+		amplitude = v
+	}
+	var period = 48.0
+	if v, ok := ctx.Value(WavePeriod).(float64); ok && v > 0 {
+		period = v
+	}
+	var bg = color.RGBA{192, 192, 192, 255}
+	if v, ok := ctx.Value(BackgroundColor).(color.RGBA); ok {
+		bg = v
+	}
+	rnd := rng(ctx)
+	return func(img draw.Image) {
+		px, py := rnd.Float64()*2*math.Pi, rnd.Float64()*2*math.Pi
+		warp(img, bg, func(x, y float64) (float64, float64) {
+			return x + amplitude*math.Sin(2*math.Pi*y/period+px),
+				y + amplitude*math.Sin(2*math.Pi*x/period+py)
+		})
+	}
+}
+
+// ElasticWarp displaces the pixels of img along a smooth random field, as if
+// the image were printed on rubber and stretched. ctx reads WarpStrength,
+// WarpScale and BackgroundColor keys.
+func ElasticWarp(ctx context.Context) Fuzzer {
+	var strength = 6.0
+	if v, ok := ctx.Value(WarpStrength).(float64); ok {
+		strength = v
+	}
+	var scale = 24.0
+	if v, ok := ctx.Value(WarpScale).(float64); ok && v > 0 {
+		scale = v
+	}
+	var bg = color.RGBA{192, 192, 192, 255}
+	if v, ok := ctx.Value(BackgroundColor).(color.RGBA); ok {
+		bg = v
+	}
+	rnd := rng(ctx)
+	return func(img draw.Image) {
+		p := newPerlin(rnd)
+		warp(img, bg, func(x, y float64) (float64, float64) {
+			nx, ny := x/scale, y/scale
+			// offset the second field so that both axes move independently
+			return x + strength*p.octaves(nx, ny, 2),
+				y + strength*p.octaves(nx+17.3, ny+31.1, 2)
+		})
+	}
+}
+
+// Crowd moves the glyphs of img closer together, so that they overlap and
+// can't be told apart by the gaps between them. Glyphs are the columns of
+// pixels that differ from the background. ctx reads CrowdOverlap and
+// BackgroundColor keys.
+func Crowd(ctx context.Context) Fuzzer {
+	var overlap = 4
+	if v, ok := ctx.Value(CrowdOverlap).(int); ok {
+		overlap = v
+	}
+	var bg = color.RGBA{192, 192, 192, 255}
+	if v, ok := ctx.Value(BackgroundColor).(color.RGBA); ok {
+		bg = v
+	}
+	return func(img draw.Image) {
+		src := snapshot(img)
+		b := src.Bounds()
+		runs := inkRuns(src, bg)
+		if len(runs) < 2 {
+			return
+		}
+		width := 0
+		for i, r := range runs {
+			width += r[1] - r[0]
+			if i > 0 {
+				width -= overlap
+			}
+		}
+		draw.Draw(img, b, &image.Uniform{bg}, image.ZP, draw.Src)
+		dx := b.Min.X + (b.Dx()-width)/2
+		for _, r := range runs {
+			for x := r[0]; x < r[1]; x++ {
+				for y := b.Min.Y; y < b.Max.Y; y++ {
+					if c := src.RGBAAt(x, y); c != bg {
+						img.Set(dx+x-r[0], y, c)
+					}
+				}
+			}
+			dx += r[1] - r[0] - overlap
+		}
+	}
+}
+
+// inkRuns returns the runs of columns of img, [start, end), with pixels
+// other than bg.
+func inkRuns(img *image.RGBA, bg color.RGBA) [][2]int {
+	b := img.Bounds()
+	inked := func(x int) bool {
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			if img.RGBAAt(x, y) != bg {
+				return true
+			}
+		}
+		return false
+	}
+	var runs [][2]int
+	start := -1
+	for x := b.Min.X; x <= b.Max.X; x++ {
+		switch ink := x < b.Max.X && inked(x); {
+		case ink && start < 0:
+			start = x
+		case !ink && start >= 0:
+			runs = append(runs, [2]int{start, x})
+			start = -1
+		}
+	}
+	return runs
+}
+
+// snapshot returns a copy of img.
+func snapshot(img image.Image) *image.RGBA {
+	cp := image.NewRGBA(img.Bounds())
+	draw.Draw(cp, cp.Bounds(), img, img.Bounds().Min, draw.Src)
+	return cp
+}
+
+// warp replaces every pixel of img with the one at src(x, y) in the
+// original image, interpolated bilinearly. Pixels from outside the image are
+// bg.
+func warp(img draw.Image, bg color.RGBA, src func(x, y float64) (float64, float64)) {
+	orig := snapshot(img)
+	b := orig.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			sx, sy := src(float64(x), float64(y))
+			img.Set(x, y, bilinear(orig, sx, sy, bg))
+		}
+	}
+}
+
+// bilinear interpolates the color of img at x, y from its four nearest
+// pixels. Pixels outside img are bg.
+func bilinear(img *image.RGBA, x, y float64, bg color.RGBA) color.RGBA {
+	x0, y0 := math.Floor(x), math.Floor(y)
+	fx, fy := x-x0, y-y0
+	ix, iy := int(x0), int(y0)
+	at := func(x, y int) color.RGBA {
+		if !(image.Point{x, y}.In(img.Rect)) {
+			return bg
+		}
+		return img.RGBAAt(x, y)
+	}
+	c00, c10 := at(ix, iy), at(ix+1, iy)
+	c01, c11 := at(ix, iy+1), at(ix+1, iy+1)
+	mix := func(a, b, c, d uint8) uint8 {
+		top := float64(a)*(1-fx) + float64(b)*fx
+		bottom := float64(c)*(1-fx) + float64(d)*fx
+		return uint8(math.Round(top*(1-fy) + bottom*fy))
+	}
+	return color.RGBA{
+		mix(c00.R, c10.R, c01.R, c11.R),
+		mix(c00.G, c10.G, c01.G, c11.G),
+		mix(c00.B, c10.B, c01.B, c11.B),
+		mix(c00.A, c10.A, c01.A, c11.A),
+	}
+}
@@ -0,0 +1,273 @@
+package draw
+
+import (
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"io"
+	"sort"
+)
+
+// EncodeWebP writes img to w as a lossless WebP (VP8L) image. Only the
+// subtract green transform and a single prefix code per channel are used, no
+// backward references nor color cache, so the files are larger than those
+// of libwebp, but any WebP decoder reads them.
+func EncodeWebP(w io.Writer, img image.Image) error {
+	b := img.Bounds()
+	if b.Dx() < 1 || b.Dy() < 1 || b.Dx() > 1<<14 || b.Dy() > 1<<14 {
+		return errors.New("draw: WebP images must be 1 to 16384 pixels wide and tall")
+	}
+
+	// green, red - green, blue - green and alpha of every pixel
+	pix := make([][4]uint8, 0, b.Dx()*b.Dy())
+	var hist [4][]int
+	for i := range hist {
+		hist[i] = make([]int, 256)
+	}
+	alpha := false
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			p := [4]uint8{c.G, c.R - c.G, c.B - c.G, c.A}
+			for i, v := range p {
+				hist[i][v]++
+			}
+			alpha = alpha || c.A != 0xff
+			pix = append(pix, p)
+		}
+	}
+
+	bw := &bitWriter{}
+	bw.write(0x2f, 8)
+	bw.write(uint32(b.Dx()-1), 14)
+	bw.write(uint32(b.Dy()-1), 14)
+	if alpha {
+		bw.write(1, 1)
+	} else {
+		bw.write(0, 1)
+	}
+	bw.write(0, 3) // version
+	// subtract green transform, and no more transforms
+	bw.write(1, 1)
+	bw.write(2, 2)
+	bw.write(0, 1)
+	bw.write(0, 1) // no color cache
+	bw.write(0, 1) // a single group of prefix codes
+
+	var codes [4]prefixCode
+	for i := range hist {
+		size := 256
+		if i == 0 {
+			// green shares its alphabet with the 24 length prefixes
+			size = 256 + 24
+		}
+		codes[i] = writePrefixCode(bw, hist[i], size)
+	}
+	// unused distance code
+	writePrefixCode(bw, nil, 40)
+	for _, p := range pix {
+		for i, v := range p {
+			codes[i].write(bw, int(v))
+		}
+	}
+	data := bw.flush()
+
+	pad := len(data) % 2
+	header := make([]byte, 20)
+	copy(header, "RIFF")
+	binary.LittleEndian.PutUint32(header[4:], uint32(12+len(data)+pad))
+	copy(header[8:], "WEBPVP8L")
+	binary.LittleEndian.PutUint32(header[16:], uint32(len(data)))
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	if pad > 0 {
+		_, err := w.Write([]byte{0})
+		return err
+	}
+	return nil
+}
+
+// bitWriter writes bits least significant first, as VP8L reads them.
+type bitWriter struct {
+	buf  []byte
+	acc  uint64
+	nacc uint
+}
+
+func (bw *bitWriter) write(v uint32, n uint) {
+	bw.acc |= uint64(v) << bw.nacc
+	bw.nacc += n
+	for bw.nacc >= 8 {
+		bw.buf = append(bw.buf, byte(bw.acc))
+		bw.acc >>= 8
+		bw.nacc -= 8
+	}
+}
+
+func (bw *bitWriter) flush() []byte {
+	if bw.nacc > 0 {
+		bw.buf = append(bw.buf, byte(bw.acc))
+		bw.acc, bw.nacc = 0, 0
+	}
+	return bw.buf
+}
+
+// prefixCode canonical huffman code, codes are stored bit reversed, ready to
+// be written.
+type prefixCode struct {
+	lengths []uint8
+	codes   []uint16
+}
+
+func (pc prefixCode) write(bw *bitWriter, symbol int) {
+	bw.write(uint32(pc.codes[symbol]), uint(pc.lengths[symbol]))
+}
+
+// codeLengthCodeOrder order the code length code lengths are written in.
+var codeLengthCodeOrder = [19]int{17, 18, 0, 1, 2, 3, 4, 5, 16, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+
+// writePrefixCode writes the prefix code for the symbols counted in hist, of
+// an alphabet of size symbols, and returns it.
+func writePrefixCode(bw *bitWriter, hist []int, size int) prefixCode {
+	var used []int
+	for s, n := range hist {
+		if n > 0 {
+			used = append(used, s)
+		}
+	}
+	if len(used) == 0 {
+		used = []int{0}
+	}
+	if len(used) <= 2 {
+		// simple code of one or two 8 bit symbols
+		pc := prefixCode{make([]uint8, size), make([]uint16, size)}
+		bw.write(1, 1)
+		bw.write(uint32(len(used)-1), 1)
+		bw.write(1, 1)
+		bw.write(uint32(used[0]), 8)
+		if len(used) == 2 {
+			bw.write(uint32(used[1]), 8)
+			pc.lengths[used[0]], pc.lengths[used[1]] = 1, 1
+			pc.codes[used[1]] = 1
+		}
+		return pc
+	}
+
+	lengths := make([]uint8, size)
+	copy(lengths, huffmanLengths(hist, 15))
+	// the code lengths are themselves prefix coded
+	clHist := make([]int, len(codeLengthCodeOrder))
+	for _, l := range lengths {
+		clHist[l]++
+	}
+	clLengths := huffmanLengths(clHist, 7)
+	var nonzero []int
+	for s, l := range clLengths {
+		if l > 0 {
+			nonzero = append(nonzero, s)
+		}
+	}
+	if len(nonzero) == 1 {
+		// decoders expect a complete code, pair it with an unused symbol
+		clLengths[(nonzero[0]+1)%len(clLengths)] = 1
+	}
+	n := len(codeLengthCodeOrder)
+	for n > 4 && clLengths[codeLengthCodeOrder[n-1]] == 0 {
+		n--
+	}
+	bw.write(0, 1)
+	bw.write(uint32(n-4), 4)
+	for _, s := range codeLengthCodeOrder[:n] {
+		bw.write(uint32(clLengths[s]), 3)
+	}
+	bw.write(0, 1) // code lengths for the whole alphabet
+	cl := canonicalCode(clLengths)
+	for _, l := range lengths {
+		cl.write(bw, int(l))
+	}
+	return canonicalCode(lengths)
+}
+
+// huffmanLengths returns the huffman code lengths of the symbols counted in
+// hist, no longer than limit bits. Symbols that don't appear get 0, a lone
+// symbol gets 1.
+func huffmanLengths(hist []int, limit uint8) []uint8 {
+	counts := append([]int(nil), hist...)
+	for {
+		type node struct {
+			weight  int
+			symbols []int
+		}
+		var nodes []node
+		for s, c := range counts {
+			if c > 0 {
+				nodes = append(nodes, node{c, []int{s}})
+			}
+		}
+		lengths := make([]uint8, len(counts))
+		if len(nodes) == 1 {
+			lengths[nodes[0].symbols[0]] = 1
+			return lengths
+		}
+		for len(nodes) > 1 {
+			sort.SliceStable(nodes, func(i, j int) bool { return nodes[i].weight < nodes[j].weight })
+			a, b := nodes[0], nodes[1]
+			merged := node{a.weight + b.weight, make([]int, 0, len(a.symbols)+len(b.symbols))}
+			for _, s := range append(a.symbols, b.symbols...) {
+				lengths[s]++
+				merged.symbols = append(merged.symbols, s)
+			}
+			nodes = append(nodes[2:], merged)
+		}
+		longest := uint8(0)
+		for _, l := range lengths {
+			if l > longest {
+				longest = l
+			}
+		}
+		if longest <= limit {
+			return lengths
+		}
+		// flatten the distribution until the tree is shallow enough
+		for i, c := range counts {
+			if c > 0 {
+				counts[i] = (c + 1) / 2
+			}
+		}
+	}
+}
+
+// canonicalCode assigns the canonical codes of lengths, as the decoder does.
+func canonicalCode(lengths []uint8) prefixCode {
+	pc := prefixCode{lengths, make([]uint16, len(lengths))}
+	var count [16]int
+	for _, l := range lengths {
+		count[l]++
+	}
+	count[0] = 0
+	var next [16]uint16
+	code := uint16(0)
+	for l := 1; l < len(next); l++ {
+		code = (code + uint16(count[l-1])) << 1
+		next[l] = code
+	}
+	for s, l := range lengths {
+		if l == 0 {
+			continue
+		}
+		c := next[l]
+		next[l]++
+		// reverse, the decoder reads the most significant bit first
+		var r uint16
+		for i := uint8(0); i < l; i++ {
+			r = r<<1 | (c>>i)&1
+		}
+		pc.codes[s] = r
+	}
+	return pc
+}
@@ -0,0 +1,302 @@
+package gotcha
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math/bits"
+)
+
+// flacBlockSize samples per FLAC frame.
+const flacBlockSize = 4096
+
+type flacEncoder struct{}
+
+func (flacEncoder) Ext() string         { return ".flac" }
+func (flacEncoder) ContentType() string { return "audio/flac" }
+
+// Encode writes samples as a 16 bit mono FLAC stream. Each frame is coded
+// as a constant, with the fixed predictor that leaves the smallest Rice
+// coded residual, or verbatim.
+func (flacEncoder) Encode(w io.Writer, samples []int16, sampleRate int) error {
+	if sampleRate <= 0 || sampleRate >= 1<<20 {
+		return errors.New("flac: sample rate out of range")
+	}
+	bw := &msbWriter{}
+	bw.buf = append(bw.buf, "fLaC"...)
+
+	// STREAMINFO, the last metadata block
+	bw.write(1, 1)
+	bw.write(0, 7)
+	bw.write(34, 24)
+	bw.write(flacBlockSize, 16)
+	bw.write(flacBlockSize, 16)
+	bw.write(0, 24) // frame sizes unknown
+	bw.write(0, 24)
+	bw.write(uint64(sampleRate), 20)
+	bw.write(0, 3)  // one channel
+	bw.write(15, 5) // 16 bits per sample
+	bw.write(uint64(len(samples)), 36)
+	sum := md5.New()
+	pcm := make([]byte, 2*len(samples))
+	for i, s := range samples {
+		binary.LittleEndian.PutUint16(pcm[2*i:], uint16(s))
+	}
+	sum.Write(pcm)
+	bw.buf = append(bw.buf, sum.Sum(nil)...)
+
+	for frame := 0; frame*flacBlockSize < len(samples); frame++ {
+		end := (frame + 1) * flacBlockSize
+		if end > len(samples) {
+			end = len(samples)
+		}
+		block := samples[frame*flacBlockSize : end]
+		start := len(bw.buf)
+
+		bw.write(0x3ffe, 14) // sync code
+		bw.write(0, 1)
+		bw.write(0, 1) // fixed block size
+		bw.write(7, 4) // block size - 1 follows, in 16 bits
+		bw.write(0, 4) // sample rate from STREAMINFO
+		bw.write(0, 4) // one channel
+		bw.write(4, 3) // 16 bits per sample
+		bw.write(0, 1)
+		bw.buf = append(bw.buf, utf8Number(uint64(frame))...)
+		bw.write(uint64(len(block)-1), 16)
+		bw.buf = append(bw.buf, crc8(bw.buf[start:]))
+
+		writeSubframe(bw, block)
+		bw.align()
+		crc := crc16(bw.buf[start:])
+		bw.buf = append(bw.buf, byte(crc>>8), byte(crc))
+	}
+	_, err := w.Write(bw.buf)
+	return err
+}
+
+// writeSubframe writes block as a constant if all its samples are the same,
+// otherwise with the fixed predictor, of order 0 to 4, that codes it in the
+// fewest bits, or verbatim.
+func writeSubframe(bw *msbWriter, block []int16) {
+	constant := true
+	for _, s := range block {
+		constant = constant && s == block[0]
+	}
+	if constant {
+		bw.write(0, 8)
+		bw.write(uint64(uint16(block[0])), 16)
+		return
+	}
+	bestOrder, bestBits := -1, 16*len(block)
+	var best rice
+	for order := 0; order <= 4 && order < len(block); order++ {
+		r := riceCode(fixedResidual(block, order), len(block), order)
+		if bits := 16*order + r.bits; bits < bestBits {
+			bestOrder, bestBits, best = order, bits, r
+		}
+	}
+	bw.write(0, 1)
+	if bestOrder < 0 {
+		bw.write(1, 6) // verbatim
+		bw.write(0, 1)
+		for _, s := range block {
+			bw.write(uint64(uint16(s)), 16)
+		}
+		return
+	}
+	bw.write(uint64(8|bestOrder), 6)
+	bw.write(0, 1)
+	for _, s := range block[:bestOrder] {
+		bw.write(uint64(uint16(s)), 16)
+	}
+	bw.write(0, 2) // 4 bit rice parameters
+	bw.write(uint64(best.order), 4)
+	for _, p := range best.partitions {
+		bw.write(uint64(p.k), 4)
+		for _, r := range p.residual {
+			u := uint64(r<<1 ^ r>>63)
+			q := u >> p.k
+			for ; q >= 32; q -= 32 {
+				bw.write(0, 32)
+			}
+			bw.write(1, uint(q)+1)
+			bw.write(u&(1<<p.k-1), p.k)
+		}
+	}
+}
+
+// fixedResidual residual of block after the fixed predictor of order, for
+// the samples after the order warm-up ones.
+func fixedResidual(block []int16, order int) []int64 {
+	res := make([]int64, 0, len(block)-order)
+	for i := order; i < len(block); i++ {
+		x := func(j int) int64 { return int64(block[i-j]) }
+		var e int64
+		switch order {
+		case 0:
+			e = x(0)
+		case 1:
+			e = x(0) - x(1)
+		case 2:
+			e = x(0) - 2*x(1) + x(2)
+		case 3:
+			e = x(0) - 3*x(1) + 3*x(2) - x(3)
+		case 4:
+			e = x(0) - 4*x(1) + 6*x(2) - 4*x(3) + x(4)
+		}
+		res = append(res, e)
+	}
+	return res
+}
+
+type ricePartition struct {
+	k        uint
+	residual []int64
+}
+
+// rice residual partitioned in 2^order partitions, each with its rice
+// parameter, and its size in bits.
+type rice struct {
+	order      int
+	partitions []ricePartition
+	bits       int
+}
+
+// riceCode splits residual in the partitions, and picks the rice parameters,
+// that code it in the fewest bits. The first partition is short of the
+// predictor order warm-up samples.
+func riceCode(residual []int64, blockSize, predictor int) rice {
+	best := rice{bits: -1}
+	for order := 0; order <= 8; order++ {
+		n := blockSize >> order
+		if blockSize%(1<<order) != 0 || n <= predictor {
+			break
+		}
+		r := rice{order: order, bits: 6}
+		rest := residual
+		for p := 0; p < 1<<order; p++ {
+			m := n
+			if p == 0 {
+				m -= predictor
+			}
+			part := ricePartition{residual: rest[:m]}
+			rest = rest[m:]
+			// the best parameter is near log2 of the mean zigzag residual
+			var sum uint64
+			for _, e := range part.residual {
+				sum += uint64(e<<1 ^ e>>63)
+			}
+			est := 0
+			if len(part.residual) > 0 {
+				est = bits.Len64(sum / uint64(len(part.residual)))
+			}
+			lo, hi := est-2, est
+			if lo < 0 {
+				lo = 0
+			}
+			if hi > 14 {
+				hi = 14
+			}
+			if lo > hi {
+				lo = hi
+			}
+			size := -1
+			for k := uint(lo); k <= uint(hi); k++ {
+				b := 4
+				for _, e := range part.residual {
+					b += int(uint64(e<<1^e>>63)>>k) + 1 + int(k)
+				}
+				if size < 0 || b < size {
+					part.k, size = k, b
+				}
+			}
+			r.partitions = append(r.partitions, part)
+			r.bits += size
+		}
+		if best.bits < 0 || r.bits < best.bits {
+			best = r
+		}
+	}
+	return best
+}
+
+// utf8Number codes n as FLAC frame numbers are, like UTF-8 extended to 36
+// bits.
+func utf8Number(n uint64) []byte {
+	if n < 0x80 {
+		return []byte{byte(n)}
+	}
+	// continuation bytes carry 6 bits each
+	var cont []byte
+	for bytes := 2; bytes <= 7; bytes++ {
+		if n < 1<<(uint(5*bytes)+1) || bytes == 7 {
+			cont = make([]byte, bytes)
+			for i := bytes - 1; i > 0; i-- {
+				cont[i] = 0x80 | byte(n&0x3f)
+				n >>= 6
+			}
+			cont[0] = byte(0xff<<(8-uint(bytes))) | byte(n)
+			break
+		}
+	}
+	return cont
+}
+
+func crc8(b []byte) byte {
+	var crc byte
+	for _, v := range b {
+		crc ^= v
+		for i := 0; i < 8; i++ {
+			if crc&0x80 != 0 {
+				crc = crc<<1 ^ 0x07
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+func crc16(b []byte) uint16 {
+	var crc uint16
+	for _, v := range b {
+		crc ^= uint16(v) << 8
+		for i := 0; i < 8; i++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x8005
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// msbWriter writes bits most significant first, as FLAC reads them.
+type msbWriter struct {
+	buf  []byte
+	acc  uint64
+	nacc uint
+}
+
+// write writes the n low bits of v.
+func (bw *msbWriter) write(v uint64, n uint) {
+	for n > 32 {
+		bw.write(v>>32, n-32)
+		v, n = v&(1<<32-1), 32
+	}
+	bw.acc = bw.acc<<n | v&(1<<n-1)
+	bw.nacc += n
+	for bw.nacc >= 8 {
+		bw.buf = append(bw.buf, byte(bw.acc>>(bw.nacc-8)))
+		bw.nacc -= 8
+	}
+}
+
+// align pads the last byte with zeros.
+func (bw *msbWriter) align() {
+	if bw.nacc > 0 {
+		bw.write(0, 8-bw.nacc)
+	}
+}
@@ -0,0 +1,178 @@
+package gotcha
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	gostorage "github.com/djangulo/go-storage"
+	"github.com/mewkiz/flac"
+)
+
+// decodeFLAC decodes data with an independent decoder, which checks the CRCs
+// of the frames, and checks the MD5 of the samples against STREAMINFO.
+func decodeFLAC(t *testing.T, data []byte) (samples []int16, sampleRate int) {
+	t.Helper()
+	stream, err := flac.New(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := md5.New()
+	for {
+		f, err := stream.ParseNext()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Hash(sum)
+		for _, s := range f.Subframes[0].Samples {
+			samples = append(samples, int16(s))
+		}
+	}
+	if n := stream.Info.NSamples; n != uint64(len(samples)) {
+		t.Errorf("expected %d samples, STREAMINFO has %d", len(samples), n)
+	}
+	if !bytes.Equal(sum.Sum(nil), stream.Info.MD5sum[:]) {
+		t.Error("MD5 of the samples does not match STREAMINFO")
+	}
+	return samples, int(stream.Info.SampleRate)
+}
+
+func TestFLAC(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	noisy := sine(440, 8000)
+	for i := range noisy {
+		noisy[i] += int16(rnd.Intn(200) - 100)
+	}
+	random := make([]int16, 5000)
+	for i := range random {
+		random[i] = int16(rnd.Intn(1 << 16))
+	}
+	for _, tc := range []struct {
+		name    string
+		samples []int16
+	}{
+		{"one", []int16{-3}},
+		{"short", []int16{1, -2, 3}},
+		{"silence", make([]int16, flacBlockSize+1)},
+		{"sine", sine(440, 20000)},
+		{"noisy", noisy},
+		{"random", random},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := FLAC.Encode(&buf, tc.samples, 22050); err != nil {
+				t.Fatal(err)
+			}
+			// no frame is larger than verbatim
+			if max := 2*len(tc.samples) + 1024; buf.Len() > max {
+				t.Errorf("expected at most %d bytes, got %d", max, buf.Len())
+			}
+			got, rate := decodeFLAC(t, buf.Bytes())
+			if rate != 22050 {
+				t.Errorf("expected a sample rate of 22050, got %d", rate)
+			}
+			if !reflect.DeepEqual(got, tc.samples) {
+				t.Error("decoded samples differ")
+			}
+		})
+	}
+
+	var flac, wav bytes.Buffer
+	FLAC.Encode(&flac, noisy, 22050)
+	WAV.Encode(&wav, noisy, 22050)
+	if flac.Len() >= wav.Len() {
+		t.Errorf("expected FLAC smaller than WAV, got %d and %d bytes", flac.Len(), wav.Len())
+	}
+}
+
+func TestAudioEncoder(t *testing.T) {
+	root, err := ioutil.TempDir("", "gotcha-assets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	fs, err := gostorage.Open("fs:///assets?root=" + root + "&accept=.png,.flac")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &Manager{
+		Languages:     []string{"en"},
+		Sources:       Random,
+		Store:         &defaultStore{captchas: make(map[uint32]*Captcha)},
+		FileStorage:   fs,
+		defaultExpiry: time.Minute,
+		random:        RandomOptions{Alphabet: "ab", MinLength: 2, MaxLength: 2},
+	}
+	WithSpeaker(toneSpeaker{})(m)
+	WithAudioEncoder(FLAC)(m)
+	c, err := m.Gen(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(c.Audio, "audio.flac") {
+		t.Fatalf("expected flac audio, got %s", c.Audio)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(root, mediaPath(c.Audio)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, rate := decodeFLAC(t, data); rate != 8000 {
+		t.Errorf("expected a sample rate of 8000, got %d", rate)
+	}
+
+	// drivers that would label flac as text/html are rejected
+	m.FileStorage = &memStorage{}
+	if _, err = m.Gen(context.Background()); err == nil {
+		t.Error("expected an error storing flac in a driver that cannot label it")
+	}
+	typed := &typedStorage{}
+	m.FileStorage = typed
+	if c, err = m.Gen(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if ct := typed.types[mediaPath(c.Audio)]; ct != "audio/flac" {
+		t.Errorf("expected the audio stored as audio/flac, got %q", ct)
+	}
+	if ct := typed.types[mediaPath(c.Image)]; ct != "image/png" {
+		t.Errorf("expected the image stored as image/png, got %q", ct)
+	}
+
+	WithInlineMedia()(m)
+	if c, err = m.Gen(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(c.Audio, "data:audio/flac;base64,") {
+		t.Errorf("expected inline flac audio, got %.30s", c.Audio)
+	}
+	if !strings.HasPrefix(c.Image, "data:image/png;base64,") {
+		t.Errorf("expected inline png image, got %.30s", c.Image)
+	}
+}
+
+// typedStorage memStorage that records the Content-Type of its files.
+type typedStorage struct {
+	memStorage
+	types map[string]string
+}
+
+func (ts *typedStorage) AddFileWithContentType(r io.Reader, path, contentType string) (string, error) {
+	ts.Lock()
+	if ts.types == nil {
+		ts.types = make(map[string]string)
+	}
+	ts.types[path] = contentType
+	ts.Unlock()
+	return ts.AddFile(r, path)
+}
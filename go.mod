@@ -3,6 +3,7 @@ module github.com/djangulo/gotcha
 go 1.15
 
 require (
+	github.com/alicebob/miniredis/v2 v2.14.1
 	github.com/djangulo/go-espeak v0.1.11
 	github.com/djangulo/go-storage v0.1.0
 	github.com/dustin/go-humanize v1.0.0 // indirect
@@ -11,7 +12,11 @@ require (
 	github.com/go-bindata/go-bindata v3.1.2+incompatible // indirect
 	github.com/go-chi/chi v4.1.2+incompatible
 	github.com/go-chi/render v1.0.1
+	github.com/go-redis/redis/v8 v8.4.4
+	github.com/lib/pq v1.9.0
 	github.com/matryer/try v0.0.0-20161228173917-9ac251b645a2 // indirect
+	github.com/mattn/go-sqlite3 v1.14.5
+	github.com/mewkiz/flac v1.0.7
 	github.com/mitchellh/go-homedir v1.1.0
 	github.com/rustyoz/Mtransform v0.0.0-20190224104252-60c8c35a3681 // indirect
 	github.com/rustyoz/genericlexer v0.0.0-20190224115003-eb82fd2987bd // indirect
@@ -21,7 +26,8 @@ require (
 	github.com/spf13/viper v1.7.0
 	github.com/tdewolff/minify v2.3.6+incompatible // indirect
 	github.com/tdewolff/parse v2.3.4+incompatible // indirect
-	golang.org/x/crypto v0.0.0-20190605123033-f99c8df09eb5
+	golang.org/x/crypto v0.0.0-20200622213623-75b288015ac9
+	golang.org/x/image v0.0.0-20201208152932-35266b937fa6
 	golang.org/x/sys v0.0.0-20201101102859-da207088b7d1 // indirect
-	gonum.org/v1/gonum v0.8.1
+	golang.org/x/text v0.3.4
 )
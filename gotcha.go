@@ -3,10 +3,10 @@ package gotcha
 import (
 	"bytes"
 	"context"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"image/png"
 	"math/rand"
 	"os"
 	"path/filepath"
@@ -14,10 +14,7 @@ import (
 	"strings"
 	"sync"
 	"time"
-	"unsafe"
 
-	espeak "github.com/djangulo/go-espeak"
-	"github.com/djangulo/go-espeak/wav"
 	gostorage "github.com/djangulo/go-storage"
 
 	"github.com/djangulo/gotcha/draw"
@@ -39,6 +36,45 @@ const (
 	QuestionBank
 )
 
+// MediaMode media generated for a challenge, see the Media context key.
+type MediaMode uint8
+
+const (
+	// MediaBoth an image and audio, the default.
+	MediaBoth MediaMode = iota
+	// MediaImage the image only, for clients that skip the audio to save
+	// bandwidth.
+	MediaImage
+	// MediaAudio the audio only, for audio first accessibility.
+	MediaAudio
+)
+
+// ParseMediaMode parses "both", "image" or "audio", the empty string is
+// MediaBoth.
+func ParseMediaMode(s string) (MediaMode, error) {
+	switch strings.ToLower(s) {
+	case "", "both":
+		return MediaBoth, nil
+	case "image":
+		return MediaImage, nil
+	case "audio":
+		return MediaAudio, nil
+	}
+	return 0, fmt.Errorf("unknown media %q, expected both, image or audio", s)
+}
+
+// mediaMode returns the MediaMode of c from the media it has, the one its
+// refreshed challenges keep.
+func mediaMode(c *Captcha) MediaMode {
+	switch {
+	case c.Image == "" && c.Audio != "":
+		return MediaAudio
+	case c.Audio == "" && c.Image != "":
+		return MediaImage
+	}
+	return MediaBoth
+}
+
 // Storer interface for persistent storage.
 type Storer interface {
 	Create(c *Captcha) error
@@ -68,15 +104,57 @@ type Manager struct {
 	// Bank question bank to use.
 	Bank *Bank
 	// Math set of math symbols to use.
-	Math                *Symbols
-	Store               Storer
-	FileStorage         gostorage.Driver
+	Math        *Symbols
+	Store       Storer
+	FileStorage gostorage.Driver
+	// Clients registry of clients allowed to request captchas. If nil,
+	// captchas are not bound to clients.
+	Clients             ClientStorer
 	defaultExpiry       time.Duration
 	lifetimeAfterPassed time.Duration
-	publicURL           string
-	noGzip              bool
-	clients             []string
-	mountpoint          string
+	// maxAttempts wrong answers allowed per captcha, 0 for unlimited.
+	maxAttempts int
+	// secret shared with backends to verify captchas.
+	secret     string
+	publicURL  string
+	noGzip     bool
+	mountpoint string
+	// ipLimiter and clientLimiter limit the requests per IP address and per
+	// client, nil for no limit.
+	ipLimiter     RateLimiter
+	clientLimiter RateLimiter
+	// trustProxy take the client IP from the X-Forwarded-For and X-Real-IP
+	// headers.
+	trustProxy bool
+	// tokens signs and verifies stateless captchas, nil unless
+	// WithStatelessTokens is set.
+	tokens *tokenSigner
+	// replayStore set with WithReplayStore, replaces the in-memory one of
+	// tokens.
+	replayStore ReplayStore
+	// poolSize and poolWorkers configure the pool of pre-generated
+	// challenges, started by NewManager if poolSize > 0.
+	poolSize    int
+	poolWorkers int
+	pool        *challengePool
+	// matchers override DefaultMatcher per source.
+	matchers map[Source]*Matcher
+	// random configures the Random source.
+	random RandomOptions
+	// fonts the challenges are drawn with, Inconsolata if empty.
+	fonts []*draw.Font
+	// animations per source, static images for sources without one.
+	animations map[Source]Animation
+	// image encoding and size of the images.
+	image ImageOptions
+	// tts reads the challenges, see Manager.speaker.
+	tts Speaker
+	// audio post-processing of the challenge audio.
+	audio AudioOptions
+	// audioEncoder encodes the challenge audio, WAV if nil.
+	audioEncoder AudioEncoder
+	// inline return the media as data URIs instead of storing them.
+	inline bool
 }
 
 // func (m *Manager) Gen(lang string) (*Captcha, error) {
@@ -88,6 +166,14 @@ var (
 	ErrNoSources = errors.New("sources not set, select one of Random, Math or QuestionBank")
 	// ErrLangEmpty lang is empty.
 	ErrLangEmpty = errors.New("lang is empty")
+	// ErrWrongAnswer the answer does not match the challenge.
+	ErrWrongAnswer = errors.New("incorrect answer")
+	// ErrTooManyAttempts the captcha has been invalidated after too many
+	// wrong answers, and needs to be refreshed.
+	ErrTooManyAttempts = errors.New("too many attempts")
+	// ErrNoAudio an audio only challenge was requested, but the speaker read
+	// no audio for it.
+	ErrNoAudio = errors.New("no audio for an audio only challenge")
 )
 
 func (m *Manager) Gen(ctx context.Context) (c *Captcha, err error) {
@@ -99,60 +185,65 @@ func (m *Manager) Gen(ctx context.Context) (c *Captcha, err error) {
 	if e, ok := ctx.Value(Expiry).(time.Duration); ok {
 		exp = e
 	}
-	if m.Sources == 0 {
+	var client *Client
+	if m.Clients != nil {
+		id, _ := ctx.Value(ClientID).(string)
+		if id == "" {
+			return nil, ErrClientRequired
+		}
+		client, err = m.Clients.GetClient(id)
+		if err != nil {
+			return nil, err
+		}
+		if !client.AllowsLang(lang) {
+			return nil, ErrLangNotAllowed
+		}
+	}
+	sources := m.Sources
+	if client != nil && client.Sources != 0 {
+		sources = client.Sources
+	}
+	if sources == 0 {
 		err = ErrNoSources
 		return
 	}
 
-	switch v := m.Sources; {
-	case v == Math|QuestionBank|Random:
-		coin := rand.Float64()
-		if coin >= 0 && coin < 0.33 {
-			c, err = m.mathChallenge(ctx, lang, exp)
-		} else if coin >= 0.33 && coin < 0.66 {
-			c, err = m.randomQuery(ctx, lang, exp)
-		} else {
-			c, err = m.qAndAChallenge(ctx, lang, exp)
-		}
-	case v == Math|QuestionBank:
-		coin := rand.Float64()
-		if coin > 0.5 {
-			c, err = m.mathChallenge(ctx, lang, exp)
-		} else {
-			c, err = m.qAndAChallenge(ctx, lang, exp)
-		}
-	case v == Random|QuestionBank:
-		coin := rand.Float64()
-		if coin > 0.5 {
-			c, err = m.qAndAChallenge(ctx, lang, exp)
-		} else {
-			c, err = m.randomQuery(ctx, lang, exp)
-		}
-	case v == QuestionBank:
-		c, err = m.qAndAChallenge(ctx, lang, exp)
-	case v == Math:
-		c, err = m.mathChallenge(ctx, lang, exp)
-	case v == Random:
-		c, err = m.randomQuery(ctx, lang, exp)
-	case v == Math|Random:
-		fallthrough
-	default:
-		coin := rand.Float64()
-		if coin > 0.5 {
-			c, err = m.mathChallenge(ctx, lang, exp)
-		} else {
-			c, err = m.randomQuery(ctx, lang, exp)
+	src := pickSource(sources)
+	// the pool only keeps challenges with both media
+	if mode, _ := ctx.Value(Media).(MediaMode); m.pool != nil && mode == MediaBoth {
+		if c = m.pool.get(lang, src); c != nil {
+			c.Expiry = time.Now().Add(exp)
 		}
 	}
+	if c == nil {
+		c, err = m.challenge(ctx, src, lang, exp)
+	}
 	if err != nil {
 		return nil, err
 	}
+	m.stampMedia(c)
+	c.Lang = lang
+	c.Source = src
+	if client != nil {
+		c.ClientID = client.SiteKey
+	}
+	if m.tokens != nil {
+		mt := m.matcher(src)
+		answers := make([]string, len(c.Answers))
+		for i, a := range c.Answers {
+			answers[i] = mt.Normalize(lang, a)
+		}
+		c.Token, err = m.tokens.sign(c, answers)
+		if err != nil {
+			return nil, err
+		}
+		return
+	}
 	create := true
 	if v, ok := ctx.Value(createNew).(bool); ok {
 		create = v
 	}
 	if create {
-		c.ID = rand.Uint32()
 		err = m.Store.Create(c)
 		if err != nil {
 			return nil, err
@@ -161,20 +252,80 @@ func (m *Manager) Gen(ctx context.Context) (c *Captcha, err error) {
 	return
 }
 
+// pickSource picks one of sources at random.
+func pickSource(sources Source) Source {
+	switch v := sources; {
+	case v == Math|QuestionBank|Random:
+		coin := rand.Float64()
+		if coin >= 0 && coin < 0.33 {
+			return Math
+		} else if coin >= 0.33 && coin < 0.66 {
+			return Random
+		}
+		return QuestionBank
+	case v == Math|QuestionBank:
+		if rand.Float64() > 0.5 {
+			return Math
+		}
+		return QuestionBank
+	case v == Random|QuestionBank:
+		if rand.Float64() > 0.5 {
+			return QuestionBank
+		}
+		return Random
+	case v == QuestionBank, v == Math, v == Random:
+		return v
+	case v == Math|Random:
+		fallthrough
+	default:
+		if rand.Float64() > 0.5 {
+			return Math
+		}
+		return Random
+	}
+}
+
+// challenge renders a new challenge in lang from src.
+func (m *Manager) challenge(ctx context.Context, src Source, lang string, exp time.Duration) (*Captcha, error) {
+	switch src {
+	case QuestionBank:
+		return m.qAndAChallenge(ctx, lang, exp)
+	case Random:
+		return m.randomQuery(ctx, lang, exp)
+	default:
+		return m.mathChallenge(ctx, lang, exp)
+	}
+}
+
+// incrAttempts reserves an attempt on c before its answer is checked, and
+// returns the attempt count. It is atomic on AttemptCounter stores only, others
+// may lose attempts made concurrently.
+func (m *Manager) incrAttempts(c *Captcha) (int, error) {
+	if ac, ok := m.Store.(AttemptCounter); ok {
+		return ac.IncrAttempts(c.ID)
+	}
+	c.Attempts++
+	return c.Attempts, m.Store.Update(c.ID, c)
+}
+
 func (m *Manager) Refresh(captchaID uint32) (*Captcha, error) {
 	c, err := m.Store.Get(captchaID)
 	if err != nil {
 		return nil, err
 	}
+	// the new challenge has the same media as the one it replaces
 	ctx := context.Background()
 	ctx, err = AddToContext(ctx,
 		Language, c.Lang,
 		Expiry, c.Expiry,
+		ClientID, c.ClientID,
+		Media, mediaMode(c),
 		createNew, false,
 	)
 	if err != nil {
 		return nil, err
 	}
+	old := c
 	c, err = m.Gen(ctx)
 	if err != nil {
 		return nil, err
@@ -184,18 +335,35 @@ func (m *Manager) Refresh(captchaID uint32) (*Captcha, error) {
 	if err != nil {
 		return nil, err
 	}
+	// the captcha is refreshed even if its old media are not removed,
+	// SweepAssets removes them later
+	m.removeMedia(old)
 	return c, nil
 }
 
 // GC needs to be run in a goroutine to clean expired captcahs. It'll start a
-// time.Ticker every unit and periodically call the store GC method.
+// time.Ticker every unit and periodically call the store GC method, removing
+// the media of the expired captchas if the store is an Expirer, and
+// SweepAssets.
 func (m *Manager) GC(unit time.Duration, errChan chan<- error, cancel <-chan struct{}) {
 
 	tick := time.Tick(1 * unit)
 	for {
 		select {
 		case <-tick:
-			if err := m.Store.GC(); err != nil {
+			for _, rl := range []RateLimiter{m.ipLimiter, m.clientLimiter} {
+				if gc, ok := rl.(interface{ GC() }); ok {
+					gc.GC()
+				}
+			}
+			if m.tokens != nil {
+				if gc, ok := m.tokens.replay.(interface{ GC() }); ok {
+					gc.GC()
+				}
+			} else if err := m.gcStore(); err != nil {
+				errChan <- err
+			}
+			if err := m.SweepAssets(); err != nil {
 				errChan <- err
 			}
 		case <-cancel:
@@ -210,6 +378,15 @@ func NewManager(opts ...Option) *Manager {
 	for _, opt := range opts {
 		opt(m)
 	}
+	if err := m.checkEncoder(); err != nil {
+		panic(err)
+	}
+	if m.tokens != nil && m.replayStore != nil {
+		m.tokens.replay = m.replayStore
+	}
+	if m.poolSize > 0 && m.pool == nil {
+		m.pool = newChallengePool(m, m.poolSize, m.poolWorkers)
+	}
 	return m
 }
 
@@ -221,14 +398,25 @@ type Challenge interface {
 type Captcha struct {
 	// ID of this Captcha.
 	ID uint32 `json:"id,omitempty"`
-	// Img url to image file on server.
+	// Token signed token that replaces ID in stateless mode. It is used in
+	// place of the ID in the check and refresh routes.
+	Token string `json:"token,omitempty"`
+	// Img url to image file on server, or data URI with WithInlineMedia.
 	Image string `json:"image-url,omitempty"`
 	// Passed status of this captcha.
-	Passed   bool   `json:"passed"`
+	Passed bool `json:"passed"`
+	// Attempts number of answers given.
+	Attempts int `json:"attempts"`
+	// PassedAt when the captcha was passed.
+	PassedAt time.Time `json:"passed-at,omitempty"`
+	// Hostname of the site the captcha was passed on.
+	Hostname string `json:"hostname,omitempty"`
 	ClientID string `json:"client-id"`
 	// Lang language.
 	Lang string `json:"language,omitempty"`
-	// Audio url to audio file on server.
+	// Source the challenge was generated from.
+	Source Source `json:"source,omitempty"`
+	// Audio url to audio file on server, or data URI with WithInlineMedia.
 	Audio string `json:"audio-url,omitempty"`
 	// Question the question posed in the captcha.
 	Question string `json:"question,omitempty"`
@@ -238,6 +426,8 @@ type Captcha struct {
 	Expiry time.Time `json:"expiry,omitempty"`
 }
 
+// Match reports whether ans is exactly one of the answers. See Manager.Match
+// to compare them with the Matcher of the captcha source.
 func (q *Captcha) Match(ans string) bool {
 	for _, a := range q.Answers {
 		if a == ans {
@@ -268,7 +458,14 @@ var (
 		Bank:                NewBank(defaultLangs...),
 		defaultExpiry:       10 * time.Minute,
 		lifetimeAfterPassed: 2 * time.Minute,
+		maxAttempts:         5,
 		Store:               DefaultStore,
+		random: RandomOptions{
+			Alphabet:        UnambiguousAlphabet,
+			MinLength:       6,
+			MaxLength:       6,
+			CaseInsensitive: true,
+		},
 	}
 )
 
@@ -310,14 +507,15 @@ func init() {
 // }
 
 func (m *Manager) randomQuery(ctx context.Context, lang string, exp time.Duration) (*Captcha, error) {
-	str := randomString(6)
+	str := randomString(m.random)
 	c := &Captcha{
+		ID:       rand.Uint32(),
 		Question: str,
 		Answers:  []string{str},
 		Expiry:   time.Now().Add(exp),
 	}
 	var err error
-	c.Image, c.Audio, err = m.getMedia(ctx, c.ID, lang, str)
+	c.Image, c.Audio, err = m.getMedia(ctx, c.ID, Random, lang, str)
 	if err != nil {
 		return nil, err
 	}
@@ -343,51 +541,142 @@ var fuzzerPool = fPool{
 	// {draw.RandomLines},
 }
 
+// warpPool fuzzers that deform the glyphs, one of them is applied before the
+// fuzzerPool ones.
+var warpPool = []draw.FuzzFactory{draw.SineWave, draw.ElasticWarp}
+
+// Animation configures the animated images of a source, see WithAnimation.
+type Animation struct {
+	// Mode draw.AnimateNoise or draw.AnimateReveal, defaults to
+	// draw.AnimateNoise.
+	Mode draw.AnimationMode
+	// Frames showing the whole text, defaults to 8.
+	Frames int
+	// Delay between frames, defaults to 200ms.
+	Delay time.Duration
+	// APNG encode as animated PNG instead of GIF.
+	APNG bool
+}
+
+// context sets the draw animation keys from a.
+func (a Animation) context(ctx context.Context) context.Context {
+	if a.Mode != 0 {
+		ctx = context.WithValue(ctx, draw.Animate, a.Mode)
+	}
+	if a.Frames > 0 {
+		ctx = context.WithValue(ctx, draw.AnimationFrames, a.Frames)
+	}
+	if a.Delay > 0 {
+		ctx = context.WithValue(ctx, draw.AnimationDelay, a.Delay)
+	}
+	return ctx
+}
+
 func (m *Manager) getMedia(
 	ctx context.Context,
 	id uint32,
+	src Source,
 	lang string,
 	q string,
 ) (imgURL string, audioURL string, err error) {
-	var fuzzers = make([]draw.Fuzzer, 0)
-	for _, f := range fuzzerPool.rand() {
-		fuzzers = append(fuzzers, f(ctx))
+	mode, _ := ctx.Value(Media).(MediaMode)
+	if mode != MediaAudio {
+		imgURL, err = m.genImage(ctx, id, src, q)
+		if err != nil || mode == MediaImage {
+			return imgURL, "", err
+		}
 	}
-	img := draw.Gen(
-		q,
-		draw.Inconsolata(ctx),
-		fuzzers...)
-
-	var buf bytes.Buffer
-	png.Encode(&buf, img)
-
-	imgURL, err = m.FileStorage.AddFile(
-		&buf,
-		filepath.Join(strconv.Itoa(int(id)), "image.png"),
-	)
+	audioURL, err = m.genAudio(ctx, id, src, lang, q)
 	if err != nil {
 		return "", "", err
 	}
-	buf.Reset()
+	if audioURL == "" && mode == MediaAudio {
+		return "", "", ErrNoAudio
+	}
+	return imgURL, audioURL, nil
+}
 
-	v, err := espeak.VoiceFromSpec(&espeak.Voice{Languages: lang})
-	if err != nil {
-		return "", "", err
+// genImage draws q, and stores the image of challenge id.
+func (m *Manager) genImage(ctx context.Context, id uint32, src Source, q string) (string, error) {
+	var fuzzers = append([]draw.FuzzFactory{warpPool[rand.Intn(len(warpPool))]}, fuzzerPool.rand()...)
+	var fd draw.FontDrawer
+	if len(m.fonts) > 0 {
+		var err error
+		if fd, err = draw.OpenType(ctx, m.fonts...); err != nil {
+			return "", err
+		}
+	} else {
+		fd = draw.Inconsolata(ctx)
 	}
-	audioSamples, err := espeak.GenSamples(q, v, nil)
-	if err != nil {
-		return "", "", err
+
+	var buf bytes.Buffer
+	var name string
+	if a, ok := m.animations[src]; ok {
+		anim := draw.GenAnimation(a.context(ctx), q, fd, fuzzers...)
+		if m.image.Width > 0 || m.image.Height > 0 {
+			for i, f := range anim.Frames {
+				anim.Frames[i] = draw.Fit(f, m.image.Width, m.image.Height)
+			}
+		}
+		name = "image.png"
+		var err error
+		if a.APNG {
+			err = anim.EncodeAPNG(&buf)
+		} else {
+			name = "image.gif"
+			err = anim.EncodeGIF(&buf)
+		}
+		if err != nil {
+			return "", err
+		}
+	} else {
+		var fz []draw.Fuzzer
+		for _, f := range fuzzers {
+			fz = append(fz, f(ctx))
+		}
+		data, n, err := m.image.encode(draw.GenContext(ctx, q, fd, fz...))
+		if err != nil {
+			return "", err
+		}
+		buf.Write(data)
+		name = n
 	}
-	wav.NewWriter(&buf, espeak.SampleRate()).WriteSamples(audioSamples)
-	audioURL, err = m.FileStorage.AddFile(
-		&buf,
-		filepath.Join(strconv.Itoa(int(id)), "audio.wav"),
-	)
+
+	return m.addFile(id, name, &buf)
+}
+
+// genAudio reads q, and stores the audio of challenge id. It returns an empty
+// URL if the speaker of m has nothing to read.
+func (m *Manager) genAudio(ctx context.Context, id uint32, src Source, lang, q string) (string, error) {
+	audioSamples, sampleRate, err := m.speak(ctx, src, lang, q)
 	if err != nil {
-		return "", "", err
+		return "", err
+	}
+	if len(audioSamples) == 0 {
+		return "", nil
+	}
+	if err := m.checkEncoder(); err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	enc := m.encoder()
+	if err := enc.Encode(&buf, audioSamples, sampleRate); err != nil {
+		return "", err
 	}
+	return m.addFile(id, "audio"+enc.Ext(), &buf)
+}
 
-	return
+// addFile stores the media file name of challenge id, and returns its URL.
+// With inline media it stores nothing and returns a data URI instead.
+func (m *Manager) addFile(id uint32, name string, buf *bytes.Buffer) (string, error) {
+	if m.inline {
+		return "data:" + m.contentType(name) + ";base64," + base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+	}
+	p := filepath.Join(strconv.Itoa(int(id)), name)
+	if ca, ok := m.FileStorage.(ContentTypeAdder); ok {
+		return ca.AddFileWithContentType(buf, p, m.contentType(name))
+	}
+	return m.FileStorage.AddFile(buf, p)
 }
 
 // Bank contains arrays a map[string][]*Captcha, under each language.
@@ -410,11 +699,14 @@ func (m *Manager) qAndAChallenge(ctx context.Context, lang string, exp time.Dura
 	if len(m.Bank.Values) == 0 || len(m.Bank.Values[lang]) == 0 {
 		return nil, fmt.Errorf("bank is empty")
 	}
-	c := m.Bank.Values[lang][rand.Intn(len(m.Bank.Values[lang]))]
+	// copy the entry, as it's shared among all the challenges
+	entry := *m.Bank.Values[lang][rand.Intn(len(m.Bank.Values[lang]))]
+	c := &entry
+	c.ID = rand.Uint32()
 	c.Expiry = time.Now().Add(exp)
 
 	var err error
-	c.Image, c.Audio, err = m.getMedia(ctx, c.ID, lang, c.Question)
+	c.Image, c.Audio, err = m.getMedia(ctx, c.ID, QuestionBank, lang, c.Question)
 	if err != nil {
 		return nil, err
 	}
@@ -513,12 +805,13 @@ func (m *Manager) mathChallenge(ctx context.Context, lang string, exp time.Durat
 		}
 	}
 	c := &Captcha{
+		ID:       rand.Uint32(),
 		Question: q,
 		Answers:  []string{numberAns, stringAns},
 		Expiry:   time.Now().Add(exp),
 	}
 
-	c.Image, c.Audio, err = m.getMedia(ctx, c.ID, lang, q)
+	c.Image, c.Audio, err = m.getMedia(ctx, c.ID, Math, lang, q)
 	if err != nil {
 		return nil, err
 	}
@@ -639,30 +932,47 @@ func parseExpr(expr string) (string, error) {
 	}
 }
 
+// Alphabets for the Random source.
 const (
-	chars      = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
-	bitsNeeded = 6 // len(chars[:63]) = 62 = 0b111110
-	mask       = 1<<bitsNeeded - 1
-	max        = 63 / bitsNeeded
+	// AlphanumericAlphabet ascii letters and digits.
+	AlphanumericAlphabet = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+	// UnambiguousAlphabet lowercase letters and digits, without the ones
+	// easily confused once distorted: 0/o, 1/i/l, 2/z and 5/s.
+	UnambiguousAlphabet = "346789abcdefghjkmnpqrtuvwxy"
 )
 
-// randomString generate a random string of length n
-// Modified from https://stackoverflow.com/a/31832326
-func randomString(n int) string {
-	b := make([]byte, n)
-	for i, cache, remain := n-1, rand.Int63(), max; i >= 0; {
-		if remain == 0 {
-			cache, remain = rand.Int63(), max
-		}
-		if idx := int(cache & mask); idx < len(chars) {
-			b[i] = chars[idx]
-			i--
-		}
-		cache >>= bitsNeeded
-		remain--
-	}
+// RandomOptions configures the Random source.
+type RandomOptions struct {
+	// Alphabet characters the strings are made of, defaults to
+	// UnambiguousAlphabet.
+	Alphabet string
+	// MinLength and MaxLength inclusive range of the string length, defaults
+	// to 6.
+	MinLength int
+	MaxLength int
+	// CaseInsensitive accept answers regardless of case.
+	CaseInsensitive bool
+}
 
-	return *(*string)(unsafe.Pointer(&b))
+// randomString generates a random string of o.MinLength to o.MaxLength
+// characters from o.Alphabet.
+func randomString(o RandomOptions) string {
+	alphabet := []rune(o.Alphabet)
+	if len(alphabet) == 0 {
+		alphabet = []rune(UnambiguousAlphabet)
+	}
+	min, max := o.MinLength, o.MaxLength
+	if min < 1 {
+		min = 6
+	}
+	if max < min {
+		max = min
+	}
+	b := make([]rune, min+rand.Intn(max-min+1))
+	for i := range b {
+		b[i] = alphabet[rand.Intn(len(alphabet))]
+	}
+	return string(b)
 }
 
 func readJSON(path string, target interface{}) error {
@@ -683,6 +993,8 @@ var DefaultStore Storer = &defaultStore{
 	captchas: make(map[uint32]*Captcha),
 }
 
+// defaultStore keeps the captchas in memory. It stores and returns copies,
+// so that concurrent requests on the same captcha do not share one.
 type defaultStore struct {
 	sync.Mutex
 	captchas map[uint32]*Captcha
@@ -691,7 +1003,8 @@ type defaultStore struct {
 func (ds *defaultStore) Create(c *Captcha) error {
 	ds.Lock()
 	defer ds.Unlock()
-	ds.captchas[c.ID] = c
+	cc := *c
+	ds.captchas[c.ID] = &cc
 	return nil
 }
 
@@ -699,17 +1012,19 @@ func (ds *defaultStore) Get(id uint32) (*Captcha, error) {
 	ds.Lock()
 	defer ds.Unlock()
 	if c, ok := ds.captchas[id]; ok {
-		return c, nil
+		cc := *c
+		return &cc, nil
 	}
 
-	return nil, fmt.Errorf("not found")
+	return nil, ErrCaptchaNotFound
 }
 
 func (ds *defaultStore) Update(id uint32, c *Captcha) error {
 	ds.Lock()
 	defer ds.Unlock()
 
-	ds.captchas[id] = c
+	cc := *c
+	ds.captchas[id] = &cc
 	return nil
 }
 
@@ -722,6 +1037,45 @@ func (ds *defaultStore) Delete(id uint32) error {
 	return nil
 }
 
+func (ds *defaultStore) IncrAttempts(id uint32) (int, error) {
+	ds.Lock()
+	defer ds.Unlock()
+
+	c, ok := ds.captchas[id]
+	if !ok {
+		return 0, ErrCaptchaNotFound
+	}
+	c.Attempts++
+	return c.Attempts, nil
+}
+
+func (ds *defaultStore) Take(id uint32) (*Captcha, error) {
+	ds.Lock()
+	defer ds.Unlock()
+
+	c, ok := ds.captchas[id]
+	if !ok {
+		return nil, ErrCaptchaNotFound
+	}
+	delete(ds.captchas, id)
+	return c, nil
+}
+
+// Expired returns the expired captchas.
+func (ds *defaultStore) Expired() ([]*Captcha, error) {
+	ds.Lock()
+	defer ds.Unlock()
+
+	now := time.Now()
+	var expired []*Captcha
+	for _, c := range ds.captchas {
+		if now.After(c.Expiry) {
+			expired = append(expired, c)
+		}
+	}
+	return expired, nil
+}
+
 func (ds *defaultStore) GC() error {
 	ds.Lock()
 	defer ds.Unlock()
@@ -729,10 +1083,8 @@ func (ds *defaultStore) GC() error {
 	now := time.Now()
 	for id, c := range ds.captchas {
 		if now.After(c.Expiry) {
-			err := ds.Delete(id)
-			if err != nil {
-				return err
-			}
+			// not Delete, which would lock ds again
+			delete(ds.captchas, id)
 		}
 	}
 
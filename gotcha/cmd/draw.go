@@ -22,9 +22,14 @@ THE SOFTWARE.
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"image/color"
+	"image/png"
+	"log"
 	"math/rand"
+	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 
@@ -35,19 +40,53 @@ import (
 var (
 	// drawCmd represents the draw command
 	drawCmd = &cobra.Command{
-		Use:   "draw",
+		Use:   "draw [text]",
 		Short: "Draw some text onto an image.",
 		Run: func(cmd *cobra.Command, args []string) {
-			fmt.Println("draw called")
+			ctx := drawContext(cmd)
+			text := strings.Join(args, " ")
+			fh, err := os.Create(outfile)
+			if err != nil {
+				log.Fatal(err)
+			}
+			defer fh.Close()
+			if animate != "" {
+				ctx = context.WithValue(ctx, draw.Animate, animationMode(animate))
+				anim := draw.GenAnimation(ctx, text, draw.Inconsolata(ctx), fuzzers...)
+				if strings.EqualFold(filepath.Ext(outfile), ".gif") {
+					err = anim.EncodeGIF(fh)
+				} else {
+					err = anim.EncodeAPNG(fh)
+				}
+				if err != nil {
+					log.Fatal(err)
+				}
+				return
+			}
+			var fz []draw.Fuzzer
+			for _, f := range fuzzers {
+				fz = append(fz, f(ctx))
+			}
+			img := draw.GenContext(ctx, text, draw.Inconsolata(ctx), fz...)
+			if err := png.Encode(fh, img); err != nil {
+				log.Fatal(err)
+			}
 		},
 	}
-	fuzzers    fuzzerArg
-	thickness  int
-	slope      randFloat64Arg
-	noise      float64
-	col1, col2 colorArg
-	scale      float64
-	rotation   int
+	fuzzers        fuzzerArg
+	thickness      int
+	slope          randFloat64Arg
+	noise          float64
+	col1, col2     colorArg
+	scale          float64
+	rotation       int
+	background     backgroundArg
+	bgCol1, bgCol2 colorArg
+	bgScale        float64
+	bgDensity      float64
+	textures       string
+	seed           int64
+	animate        string
 )
 
 func init() {
@@ -56,8 +95,9 @@ func init() {
 		&fuzzers,
 		"fuzzers",
 		"f",
-		`Comma separated list of fuzzer functions to use. Accepts any combination of
-[b]ands, [r]andom circles, [c]oncentric circles, random [l]ines. Default is a random
+		`Comma separated list of fuzzer functions to use, applied in order. Accepts any
+combination of [b]ands, [r]andom circles, [c]oncentric circles, random [l]ines,
+sine [w]ave, [e]lastic warp and glyph crowding [g]. Default is a random
 combination of two.`,
 	)
 	drawCmd.Flags().Var(&col1, "color", "Color to use for all fuzzer function.")
@@ -69,7 +109,26 @@ combination of two.`,
 	drawCmd.Flags().AddFlag(captchaCmd.Flags().Lookup("outfile"))
 	drawCmd.Flags().IntVar(&rotation, "font-rotation", 0, "Degrees to rotate fonts by.")
 	drawCmd.Flags().Float64Var(&scale, "font-scale", 1.0, "Scale fonts by this value.")
-	drawCmd.Flags().Float64Var(&scale, "font-scale", 1.0, "Scale fonts by this value.")
+	drawCmd.Flags().Var(
+		&background,
+		"background",
+		`Background to draw behind the text, before the fuzzers. One of flat, perlin,
+gradient, speckle or texture.`,
+	)
+	drawCmd.Flags().Var(&bgCol1, "bg-color", "Background color, blended with --bg-color2 by the perlin, gradient and speckle backgrounds.")
+	drawCmd.Flags().Var(&bgCol2, "bg-color2", "Second background color.")
+	drawCmd.Flags().Float64Var(&bgScale, "bg-scale", 32, "Size in pixels of the perlin background clouds.")
+	drawCmd.Flags().Float64Var(&bgDensity, "bg-density", 0.05, "Fraction of the speckle background covered with dots, 0 to 1.")
+	drawCmd.Flags().StringVar(&textures, "textures", "", "Directory with png, jpeg or gif images for the texture background.")
+	drawCmd.Flags().StringVar(
+		&animate,
+		"animate",
+		"",
+		`Draw an animation instead of a still image, either "noise" (the fuzzers move
+over the text) or "reveal" (the characters appear one after the other). Written
+as a GIF if outfile ends in .gif, as an animated PNG otherwise.`,
+	)
+	drawCmd.Flags().Int64Var(&seed, "seed", 0, "Seed for the random choices, the same seed and flags draw the same image. Random if unset.")
 	rootCmd.AddCommand(drawCmd)
 
 	// Here you will define your flags and configuration settings.
@@ -83,6 +142,77 @@ combination of two.`,
 	// drawCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
 }
 
+// drawContext sets the draw context keys from the flags of cmd, unset flags
+// are left to the draw package defaults.
+func drawContext(cmd *cobra.Command) context.Context {
+	ctx := context.Background()
+	set := func(flag string, k interface{}, v interface{}) {
+		if cmd.Flags().Changed(flag) {
+			ctx = context.WithValue(ctx, k, v)
+		}
+	}
+	set("color", draw.FuzzColor1CtxKey, color.RGBA(col1))
+	set("fg-color", draw.FuzzColor2CtxKey, color.RGBA(col2))
+	set("noise", draw.FuzzNoiseCtxKey, noise)
+	set("thickness", draw.FuzzBandThicknessCtxKey, thickness)
+	set("slope", draw.FuzzSlopeCtxKey, float64(slope))
+	set("font-scale", draw.ScaleBy, scale)
+	set("font-rotation", draw.RotateBy, rotation)
+	set("font-rotation", draw.RandomRotation, false)
+	set("background", draw.BackgroundGen, background.factory)
+	set("bg-color", draw.BackgroundColor, color.RGBA(bgCol1))
+	set("bg-color2", draw.BackgroundColor2, color.RGBA(bgCol2))
+	set("bg-scale", draw.BackgroundScale, bgScale)
+	set("bg-density", draw.BackgroundDensity, bgDensity)
+	set("textures", draw.BackgroundTextureDir, textures)
+	set("seed", draw.Rand, rand.New(rand.NewSource(seed)))
+	return ctx
+}
+
+// animationMode parses the --animate flag.
+func animationMode(name string) draw.AnimationMode {
+	switch strings.ToLower(name) {
+	case "noise":
+		return draw.AnimateNoise
+	case "reveal":
+		return draw.AnimateReveal
+	}
+	log.Fatalf("unknown animation %q, expected noise or reveal", name)
+	return 0
+}
+
+type backgroundArg struct {
+	name    string
+	factory draw.BackgroundFactory
+}
+
+func (b *backgroundArg) String() string {
+	return b.name
+}
+
+func (b *backgroundArg) Set(value string) error {
+	switch strings.ToLower(value) {
+	case "flat":
+		b.factory = nil
+	case "perlin":
+		b.factory = draw.PerlinBackground
+	case "gradient":
+		b.factory = draw.GradientBackground
+	case "speckle":
+		b.factory = draw.SpeckleBackground
+	case "texture":
+		b.factory = draw.TextureBackground
+	default:
+		return fmt.Errorf("unknown background %q", value)
+	}
+	b.name = strings.ToLower(value)
+	return nil
+}
+
+func (b *backgroundArg) Type() string {
+	return "string"
+}
+
 type fuzzerArg []draw.FuzzFactory
 
 func (f *fuzzerArg) String() string {
@@ -102,6 +232,12 @@ func (f *fuzzerArg) Set(value string) error {
 			*f = append(*f, draw.RandomLines)
 		case "c":
 			*f = append(*f, draw.ConcentricCircles)
+		case "w":
+			*f = append(*f, draw.SineWave)
+		case "e":
+			*f = append(*f, draw.ElasticWarp)
+		case "g":
+			*f = append(*f, draw.Crowd)
 		default:
 			switch v := rand.Float64(); {
 			case (v >= 0) && (v < 0.2):
@@ -39,6 +39,11 @@ var (
 	mathfiles mapArg
 	source    sources
 	quiet     bool
+
+	randomAlphabet      string
+	randomMinLength     int
+	randomMaxLength     int
+	randomCaseSensitive bool
 )
 
 // rootCmd represents the base command when called without any subcommands
@@ -51,8 +56,9 @@ customization based on a Source.
 Math source provides math problems in mixed text/numbers form:
 	6 divided by three (acceptable answers: 2, two)
 	seven × nine (acceptable answers: sixty-three, 63)
-Random source provides an n-long string of random alphanumeric characters:
-	1jiAoF (acceptable answers: 1jiAoF)
+Random source provides an n-long string of random characters, avoiding the
+ones easily confused with each other by default:
+	k3hxaf (acceptable answers: k3hxaf, K3HXAF)
 The QuestionBank source uses user-provided questions and answers, such as
 	How many legs does a horse have? (acceptable answers: 4, four)
 The QuestionBank, while being the most cumbersome, is the one source guaranteed
@@ -107,6 +113,38 @@ and should include symbol entries for operators plus [+,U+002B],
 minus [-, U+002D], times [×, U+00D7], and divided by [÷, U+00F7].
 See locale/en/symbols.json for a full example.`,
 	)
+	rootCmd.PersistentFlags().StringVar(
+		&randomAlphabet,
+		"random-alphabet",
+		"unambiguous",
+		`Characters of the Random source strings. Either "unambiguous" (no 0/o,
+1/i/l, 2/z or 5/s), "alphanumeric", or the literal characters to use.`,
+	)
+	rootCmd.PersistentFlags().IntVar(&randomMinLength, "random-min-length", 6, "Minimum length of the Random source strings.")
+	rootCmd.PersistentFlags().IntVar(&randomMaxLength, "random-max-length", 6, "Maximum length of the Random source strings.")
+	rootCmd.PersistentFlags().BoolVar(
+		&randomCaseSensitive,
+		"random-case-sensitive",
+		false,
+		"Require the answers to Random challenges to match their case.",
+	)
+}
+
+// randomOptions returns the Random source options set through the flags.
+func randomOptions() gotcha.RandomOptions {
+	alphabet := randomAlphabet
+	switch alphabet {
+	case "unambiguous":
+		alphabet = gotcha.UnambiguousAlphabet
+	case "alphanumeric":
+		alphabet = gotcha.AlphanumericAlphabet
+	}
+	return gotcha.RandomOptions{
+		Alphabet:        alphabet,
+		MinLength:       randomMinLength,
+		MaxLength:       randomMaxLength,
+		CaseInsensitive: !randomCaseSensitive,
+	}
 }
 
 // initConfig reads in config file and ENV variables if set.
@@ -0,0 +1,176 @@
+/*
+Copyright © 2020 Denis Angulo <djal@tuta.io>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"strings"
+
+	"github.com/djangulo/gotcha"
+	"github.com/spf13/cobra"
+)
+
+var (
+	// soundsCmd represents the sounds command
+	soundsCmd = &cobra.Command{
+		Use:   "sounds",
+		Short: "Records the sound pack gotcha reads challenges with when built without espeak.",
+		Long: `Records with espeak a clip for every character of the random alphabet, every
+digit, math symbol and word of the math symbols and question banks, in each
+language. Record them into static/sounds and run "make bindata" to embed them,
+static builds (CGO_ENABLED=0, or the noespeak build tag) read the challenges
+with them.
+
+With --morse, generates instead a clip in Morse code for every character of
+the challenges, read in every language. It needs no espeak, the embedded
+sound pack is generated with it.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			m := gotcha.NewManager(gotcha.WithRandom(randomOptions()))
+			if soundsMorse {
+				if err := m.RecordSpelledSoundPack(morse{}, soundsDir); err != nil {
+					log.Fatal(err)
+				}
+				return
+			}
+			s, err := gotcha.ESpeak()
+			if err != nil {
+				log.Fatal(err)
+			}
+			if err := m.RecordSoundPack(s, soundsDir); err != nil {
+				log.Fatal(err)
+			}
+		},
+	}
+	soundsDir   string
+	soundsMorse bool
+	// speakerName and soundPackDir select the speaker of serve.
+	speakerName  string
+	soundPackDir string
+)
+
+func init() {
+	rootCmd.AddCommand(soundsCmd)
+	soundsCmd.Flags().StringVarP(&soundsDir, "out", "o", "static/sounds", "Directory to record the sound pack into.")
+	soundsCmd.Flags().BoolVar(&soundsMorse, "morse", false, "Generate a Morse code sound pack, without espeak.")
+}
+
+// speaker returns the Speaker named name, nil for the default one. The sound
+// pack is read from dir, or the embedded one if dir is empty.
+func speaker(name, dir string) (gotcha.Speaker, error) {
+	switch strings.ToLower(name) {
+	case "":
+		return nil, nil
+	case "espeak":
+		return gotcha.ESpeak()
+	case "soundpack":
+		if dir != "" {
+			return gotcha.NewSoundPack(dir)
+		}
+		return gotcha.EmbeddedSoundPack()
+	case "none":
+		return gotcha.NoAudio, nil
+	default:
+		return nil, fmt.Errorf("unknown speaker %q, expected espeak, soundpack or none", name)
+	}
+}
+
+// morseCodes of the characters of the challenges, the accented ones with the
+// codes of their ITU extensions, or of their base letter. The math symbols
+// read as the letter x and the slash.
+var morseCodes = map[rune]string{
+	'a': ".-", 'b': "-...", 'c': "-.-.", 'd': "-..", 'e': ".", 'f': "..-.",
+	'g': "--.", 'h': "....", 'i': "..", 'j': ".---", 'k': "-.-", 'l': ".-..",
+	'm': "--", 'n': "-.", 'o': "---", 'p': ".--.", 'q': "--.-", 'r': ".-.",
+	's': "...", 't': "-", 'u': "..-", 'v': "...-", 'w': ".--", 'x': "-..-",
+	'y': "-.--", 'z': "--..",
+	'0': "-----", '1': ".----", '2': "..---", '3': "...--", '4': "....-",
+	'5': ".....", '6': "-....", '7': "--...", '8': "---..", '9': "----.",
+	'á': ".--.-", 'à': ".--.-", 'â': ".-", 'ä': ".-.-", 'ç': "-.-..",
+	'é': "..-..", 'è': ".-..-", 'ê': ".", 'ë': ".", 'í': "..", 'î': "..",
+	'ï': "..", 'ñ': "--.--", 'ó': "---.", 'ô': "---", 'ö': "---.",
+	'ú': "..--", 'ù': "..-", 'û': "..-", 'ü': "..--",
+	'+': ".-.-.", '-': "-....-", '×': "-..-", '÷': "-..-.", '/': "-..-.",
+	'=': "-...-", '?': "..--..", '\'': ".----.", '.': ".-.-.-", ',': "--..--",
+	':': "---...", '"': ".-..-.", '(': "-.--.", ')': "-.--.-",
+}
+
+const (
+	morseSampleRate = 8000
+	// morseUnit length of a dot, 24 words per minute.
+	morseUnit = morseSampleRate / 20
+	morseTone = 700
+)
+
+// morse Speaker that reads text in Morse code, in any language.
+type morse struct{}
+
+func (morse) Speak(ctx context.Context, text, lang string) ([]int16, int, error) {
+	var out []int16
+	silence := func(units int) {
+		out = append(out, make([]int16, units*morseUnit)...)
+	}
+	for i, word := range strings.Fields(strings.ToLower(text)) {
+		if i > 0 {
+			silence(7)
+		}
+		for j, r := range word {
+			code, ok := morseCodes[r]
+			if !ok {
+				return nil, 0, fmt.Errorf("no morse code for %q", r)
+			}
+			if j > 0 {
+				silence(3)
+			}
+			for k, c := range code {
+				if k > 0 {
+					silence(1)
+				}
+				units := 1
+				if c == '-' {
+					units = 3
+				}
+				out = append(out, morseBeep(units*morseUnit)...)
+			}
+		}
+	}
+	return out, morseSampleRate, nil
+}
+
+// morseBeep returns n samples of the tone, faded in and out over 5ms not to
+// click.
+func morseBeep(n int) []int16 {
+	const fade = morseSampleRate / 200
+	samples := make([]int16, n)
+	for i := range samples {
+		gain := 1.0
+		if i < fade {
+			gain = float64(i) / fade
+		} else if n-1-i < fade {
+			gain = float64(n-1-i) / fade
+		}
+		samples[i] = int16(gain * 16000 * math.Sin(2*math.Pi*morseTone*float64(i)/morseSampleRate))
+	}
+	return samples
+}
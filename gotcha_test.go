@@ -1,6 +1,19 @@
 package gotcha
 
-import "testing"
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"image/gif"
+	"image/png"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/djangulo/gotcha/draw"
+)
 
 func TestparseExpr(t *testing.T) {
 	for _, tt := range []struct {
@@ -20,3 +33,157 @@ func TestparseExpr(t *testing.T) {
 		}
 	}
 }
+
+func TestRandomString(t *testing.T) {
+	opts := RandomOptions{Alphabet: "ab", MinLength: 3, MaxLength: 5}
+	lengths := make(map[int]bool)
+	for i := 0; i < 200; i++ {
+		s := randomString(opts)
+		if len(s) < 3 || len(s) > 5 {
+			t.Fatalf("length out of range: %q", s)
+		}
+		if strings.Trim(s, "ab") != "" {
+			t.Fatalf("unexpected characters in %q", s)
+		}
+		lengths[len(s)] = true
+	}
+	if len(lengths) != 3 {
+		t.Errorf("expected all lengths in range, got %v", lengths)
+	}
+
+	m := &Manager{random: RandomOptions{CaseInsensitive: true}}
+	if !m.matcher(Random).Match("en", "ABC", []string{"abc"}) {
+		t.Error("expected case insensitive match")
+	}
+}
+
+func TestAnimation(t *testing.T) {
+	fs := &memStorage{}
+	m := &Manager{
+		Languages:     []string{"en"},
+		Sources:       Random,
+		Store:         &defaultStore{captchas: make(map[uint32]*Captcha)},
+		FileStorage:   fs,
+		defaultExpiry: time.Minute,
+		random:        RandomOptions{Alphabet: "ab", MinLength: 2, MaxLength: 2},
+	}
+	WithAnimation(Random|Math, Animation{Mode: draw.AnimateNoise, Frames: 3})(m)
+	c, err := m.Gen(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(c.Image, "image.gif") {
+		t.Fatalf("expected a gif, got %s", c.Image)
+	}
+	g, err := gif.DecodeAll(bytes.NewReader(fs.files[filepath.Join(strconv.Itoa(int(c.ID)), "image.gif")]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(g.Image) != 3 {
+		t.Errorf("expected 3 frames, got %d", len(g.Image))
+	}
+}
+
+func TestNoAudio(t *testing.T) {
+	fs := &memStorage{}
+	m := &Manager{
+		Languages:     []string{"en"},
+		Sources:       Random,
+		Store:         &defaultStore{captchas: make(map[uint32]*Captcha)},
+		FileStorage:   fs,
+		defaultExpiry: time.Minute,
+		random:        RandomOptions{Alphabet: "ab", MinLength: 2, MaxLength: 2},
+	}
+	WithSpeaker(NoAudio)(m)
+	c, err := m.Gen(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Audio != "" {
+		t.Errorf("expected no audio, got %s", c.Audio)
+	}
+	if _, ok := fs.files[filepath.Join(strconv.Itoa(int(c.ID)), "audio.wav")]; ok {
+		t.Error("expected no audio file")
+	}
+}
+
+func TestMediaMode(t *testing.T) {
+	fs := &memStorage{}
+	m := &Manager{
+		Languages:     []string{"en"},
+		Sources:       Random,
+		Store:         &defaultStore{captchas: make(map[uint32]*Captcha)},
+		FileStorage:   fs,
+		defaultExpiry: time.Minute,
+		random:        RandomOptions{Alphabet: "ab", MinLength: 2, MaxLength: 2},
+	}
+	WithSpeaker(toneSpeaker{})(m)
+	for _, tt := range []struct {
+		mode         MediaMode
+		image, audio bool
+	}{
+		{MediaBoth, true, true},
+		{MediaImage, true, false},
+		{MediaAudio, false, true},
+	} {
+		c, err := m.Gen(context.WithValue(context.Background(), Media, tt.mode))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (c.Image != "") != tt.image || (c.Audio != "") != tt.audio {
+			t.Errorf("media %d: expected image %v and audio %v, got %q and %q", tt.mode, tt.image, tt.audio, c.Image, c.Audio)
+		}
+		var image, audio bool
+		for name := range fs.files {
+			if filepath.Dir(name) == strconv.Itoa(int(c.ID)) {
+				image = image || strings.HasPrefix(filepath.Base(name), "image")
+				audio = audio || strings.HasPrefix(filepath.Base(name), "audio")
+			}
+		}
+		if image != tt.image || audio != tt.audio {
+			t.Errorf("media %d: expected image %v and audio %v files, got %v and %v", tt.mode, tt.image, tt.audio, image, audio)
+		}
+		r, err := m.Refresh(c.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (r.Image != "") != tt.image || (r.Audio != "") != tt.audio {
+			t.Errorf("media %d: expected the refreshed captcha to keep its media, got %q and %q", tt.mode, r.Image, r.Audio)
+		}
+	}
+
+	WithSpeaker(NoAudio)(m)
+	if _, err := m.Gen(context.WithValue(context.Background(), Media, MediaAudio)); err != ErrNoAudio {
+		t.Errorf("expected ErrNoAudio, got %v", err)
+	}
+}
+
+func TestInlineMedia(t *testing.T) {
+	m := &Manager{
+		Languages:     []string{"en"},
+		Sources:       Random,
+		Store:         &defaultStore{captchas: make(map[uint32]*Captcha)},
+		defaultExpiry: time.Minute,
+		random:        RandomOptions{Alphabet: "ab", MinLength: 2, MaxLength: 2},
+	}
+	WithSpeaker(toneSpeaker{})(m)
+	WithInlineMedia()(m)
+	c, err := m.Gen(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	const prefix = "data:image/png;base64,"
+	if !strings.HasPrefix(c.Image, prefix) {
+		t.Fatalf("expected a png data URI, got %.40s", c.Image)
+	}
+	data, err := base64.StdEncoding.DecodeString(c.Image[len(prefix):])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := png.Decode(bytes.NewReader(data)); err != nil {
+		t.Error(err)
+	}
+	if !strings.HasPrefix(c.Audio, "data:audio/wav;base64,") {
+		t.Errorf("expected a wav data URI, got %.40s", c.Audio)
+	}
+}
@@ -2,16 +2,12 @@ package gotcha
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"html/template"
 	"net/http"
-	"path"
 	"strconv"
-	"strings"
-	"sync"
 	"time"
 
-	"github.com/djangulo/go-storage"
 	"github.com/go-chi/chi"
 	"github.com/go-chi/chi/middleware"
 	"github.com/go-chi/render"
@@ -19,13 +15,18 @@ import (
 
 func (m *Manager) Router(ctx context.Context) http.Handler {
 	r := chi.NewRouter()
+	if m.trustProxy {
+		r.Use(middleware.RealIP)
+	}
 	r.Route(m.mountpoint, func(r chi.Router) {
-		r.Get("/new", m.NewCaptcha)
-		// r.Post("/register")
+		r.With(m.RateLimit).Get("/new", m.NewCaptcha)
+		r.Post("/siteverify", m.VerifyCaptcha)
+		r.With(m.RateLimit).Post("/register", m.RegisterCaptcha)
 		r.Route("/{gotchaID}", func(r chi.Router) {
+			r.Use(m.RateLimit)
 			r.Use(m.CaptchaCtx)
 			r.Post("/check", m.CheckCaptcha)
-			r.Post("/refresh", m.CheckCaptcha)
+			r.Post("/refresh", m.RefreshCaptcha)
 		})
 	})
 	return r
@@ -35,8 +36,11 @@ type CaptchaResponse struct {
 	*Captcha
 }
 
+// NewCaptchaResponse returns a response with a copy of c, as c may be shared
+// with the Store.
 func NewCaptchaResponse(c *Captcha) *CaptchaResponse {
-	return &CaptchaResponse{Captcha: c}
+	cc := *c
+	return &CaptchaResponse{Captcha: &cc}
 }
 
 func (c *CaptchaResponse) Render(w http.ResponseWriter, r *http.Request) error {
@@ -45,7 +49,20 @@ func (c *CaptchaResponse) Render(w http.ResponseWriter, r *http.Request) error {
 }
 
 func (m *Manager) NewCaptcha(w http.ResponseWriter, r *http.Request) {
-	c, err := m.Gen(r.Context())
+	ctx, err := m.clientContext(r, r.URL.Query().Get("client-id"))
+	if err != nil {
+		render.Render(w, r, ErrClient(err))
+		return
+	}
+	if id, _ := ctx.Value(ClientID).(string); !m.allowClient(w, r, id) {
+		return
+	}
+	ctx, err = mediaContext(ctx, r)
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	c, err := m.Gen(ctx)
 	if err != nil {
 		http.Error(w, err.Error(), 500)
 		return
@@ -56,8 +73,80 @@ func (m *Manager) NewCaptcha(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// mediaContext sets Media on ctx from the media query parameter of r, "both"
+// (the default), "image" or "audio".
+func mediaContext(ctx context.Context, r *http.Request) (context.Context, error) {
+	mode, err := ParseMediaMode(r.URL.Query().Get("media"))
+	if err != nil {
+		return nil, err
+	}
+	return context.WithValue(ctx, Media, mode), nil
+}
+
+type RegisterRequest struct {
+	ClientID string `json:"client-id"`
+	Lang     string `json:"language,omitempty"`
+}
+
+func (rr *RegisterRequest) Bind(r *http.Request) error {
+	return nil
+}
+
+// RegisterCaptcha is called by the js widget on init, it returns a new
+// captcha bound to the requesting client.
+func (m *Manager) RegisterCaptcha(w http.ResponseWriter, r *http.Request) {
+	data := &RegisterRequest{}
+	if err := render.Bind(r, data); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	ctx, err := m.clientContext(r, data.ClientID)
+	if err != nil {
+		render.Render(w, r, ErrClient(err))
+		return
+	}
+	if id, _ := ctx.Value(ClientID).(string); !m.allowClient(w, r, id) {
+		return
+	}
+	if data.Lang != "" {
+		ctx = context.WithValue(ctx, Language, data.Lang)
+	}
+	c, err := m.Gen(ctx)
+	if err != nil {
+		render.Render(w, r, ErrClient(err))
+		return
+	}
+	render.Status(r, 201)
+	if err := render.Render(w, r, NewCaptchaResponse(c)); err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+}
+
+// clientContext checks that clientID is registered and that the request
+// comes from one of its origins. Returns the request context with ClientID
+// set. It's a no-op if the manager has no client registry.
+func (m *Manager) clientContext(r *http.Request, clientID string) (context.Context, error) {
+	ctx := r.Context()
+	if m.Clients == nil {
+		return ctx, nil
+	}
+	if clientID == "" {
+		return nil, ErrClientRequired
+	}
+	client, err := m.Clients.GetClient(clientID)
+	if err != nil {
+		return nil, err
+	}
+	if !client.AllowsOrigin(requestHostname(r)) {
+		return nil, ErrOriginNotAllowed
+	}
+	return context.WithValue(ctx, ClientID, clientID), nil
+}
+
 type CheckRequest struct {
-	Answer string `json:"challenge-response"`
+	Answer   string `json:"challenge-response"`
+	ClientID string `json:"client-id,omitempty"`
 }
 
 func (c *CheckRequest) Bind(r *http.Request) error {
@@ -83,14 +172,44 @@ func (m *Manager) CheckCaptcha(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	captcha := r.Context().Value(CaptchaCtxKey).(*Captcha)
-	if !captcha.Match(data.Answer) {
-		if err = render.Render(w, r, ErrIncorrectAnswer); err != nil {
+	if err := m.checkClient(r, captcha, data.ClientID); err != nil {
+		render.Render(w, r, ErrClient(err))
+		return
+	}
+	if !m.allowClient(w, r, captcha.ClientID) {
+		return
+	}
+	if captcha.Token != "" {
+		answer := m.matcher(captcha.Source).Normalize(captcha.Lang, data.Answer)
+		m.checkToken(w, r, captcha.Token, answer)
+		return
+	}
+	// reserve the attempt before matching, so that concurrent guesses
+	// cannot get past maxAttempts
+	n, err := m.incrAttempts(captcha)
+	if err != nil {
+		render.Render(w, r, ErrInternalServerError)
+		return
+	}
+	if m.maxAttempts > 0 && n > m.maxAttempts {
+		render.Render(w, r, ErrAttemptsExceeded)
+		return
+	}
+	if !m.Match(captcha, data.Answer) {
+		res := ErrIncorrectAnswer
+		if m.maxAttempts > 0 && n >= m.maxAttempts {
+			res = ErrAttemptsExceeded
+		}
+		if err = render.Render(w, r, res); err != nil {
 			render.Render(w, r, ErrRender(err))
 			return
 		}
 		return
 	}
+	captcha.Attempts = n
 	captcha.Passed = true
+	captcha.PassedAt = time.Now()
+	captcha.Hostname = requestHostname(r)
 	// set expiry to the lifetime after passed, GC will take care of
 	// removing the captcha
 	captcha.Expiry = time.Now().Add(m.lifetimeAfterPassed)
@@ -106,10 +225,98 @@ func (m *Manager) CheckCaptcha(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// checkToken checks a stateless captcha.
+func (m *Manager) checkToken(w http.ResponseWriter, r *http.Request, token, answer string) {
+	switch err := m.tokens.check(
+		token,
+		answer,
+		requestHostname(r),
+		m.lifetimeAfterPassed,
+		m.maxAttempts,
+	); {
+	case errors.Is(err, ErrWrongAnswer):
+		if err := render.Render(w, r, ErrIncorrectAnswer); err != nil {
+			render.Render(w, r, ErrRender(err))
+		}
+		return
+	case errors.Is(err, ErrTooManyAttempts):
+		render.Render(w, r, ErrAttemptsExceeded)
+		return
+	case errors.Is(err, ErrInvalidToken), errors.Is(err, ErrTokenExpired), errors.Is(err, ErrTokenUsed):
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	case err != nil:
+		// the ReplayStore failed
+		render.Render(w, r, ErrUnavailable)
+		return
+	}
+	render.Status(r, 200)
+	if err := render.Render(w, r, &OKResponse{"OK"}); err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+}
+
+// VerifyCaptcha lets a backend confirm that a form submission came with a
+// passed captcha. It expects the form values "secret" and "response" (the
+// captcha ID, or token in stateless mode), and consumes the captcha.
+func (m *Manager) VerifyCaptcha(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	res := m.siteVerify(r.PostForm.Get("secret"), r.PostForm.Get("response"))
+	render.Status(r, 200)
+	if err := render.Render(w, r, res); err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+}
+
+type RefreshRequest struct {
+	ClientID string `json:"client-id"`
+}
+
+func (rr *RefreshRequest) Bind(r *http.Request) error {
+	return nil
+}
+
 func (m *Manager) RefreshCaptcha(w http.ResponseWriter, r *http.Request) {
+	data := &RefreshRequest{}
+	// the body is optional without a client registry
+	if r.ContentLength != 0 {
+		if err := render.Bind(r, data); err != nil {
+			render.Render(w, r, ErrInvalidRequest(err))
+			return
+		}
+	}
 	captcha := r.Context().Value(CaptchaCtxKey).(*Captcha)
-	var err error
-	captcha, err = m.Refresh(captcha.ID)
+	err := m.checkClient(r, captcha, data.ClientID)
+	if err != nil {
+		render.Render(w, r, ErrClient(err))
+		return
+	}
+	if !m.allowClient(w, r, captcha.ClientID) {
+		return
+	}
+	if captcha.Token != "" {
+		// stateless captchas are simply replaced by a new one, with the
+		// same media, which CaptchaCtx read from the token
+		var ctx context.Context
+		ctx, err = AddToContext(r.Context(),
+			Language, captcha.Lang,
+			ClientID, captcha.ClientID,
+		)
+		if err == nil {
+			// the replaced token cannot be passed anymore
+			err = m.tokens.replay.Spend(captcha.Token, captcha.Expiry)
+		}
+		if err == nil {
+			captcha, err = m.Gen(ctx)
+		}
+	} else {
+		captcha, err = m.Refresh(captcha.ID)
+	}
 	if err != nil {
 		if err := render.Render(w, r, ErrInternalServerError); err != nil {
 			render.Render(w, r, ErrRender(err))
@@ -124,6 +331,21 @@ func (m *Manager) RefreshCaptcha(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// checkClient rejects requests on captcha made by a client other than the
+// one it is bound to.
+func (m *Manager) checkClient(r *http.Request, captcha *Captcha, clientID string) error {
+	if m.Clients == nil && captcha.ClientID == "" {
+		return nil
+	}
+	if _, err := m.clientContext(r, clientID); err != nil {
+		return err
+	}
+	if captcha.ClientID != clientID {
+		return ErrClientMismatch
+	}
+	return nil
+}
+
 func (m *Manager) CaptchaCtx(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		var captcha *Captcha
@@ -131,13 +353,27 @@ func (m *Manager) CaptchaCtx(next http.Handler) http.Handler {
 
 		ctx := r.Context()
 
-		if captchaID := chi.URLParam(r, "captchaID"); captchaID != "" {
-			if id, err := strconv.ParseUint(captchaID, 10, 32); err != nil {
-				render.Render(w, r, ErrInvalidRequest(err))
+		if captchaID := chi.URLParam(r, "gotchaID"); captchaID != "" && m.tokens != nil {
+			var p *tokenPayload
+			p, err = m.tokens.verify(captchaID)
+			if err == nil {
+				captcha = &Captcha{
+					ID:       p.ID,
+					Token:    captchaID,
+					Lang:     p.Lang,
+					ClientID: p.ClientID,
+					Source:   p.Source,
+					Expiry:   time.Unix(p.Expiry, 0),
+				}
+				ctx = context.WithValue(ctx, Media, p.Media)
+			}
+		} else if captchaID != "" {
+			id, perr := strconv.ParseUint(captchaID, 10, 32)
+			if perr != nil {
+				render.Render(w, r, ErrInvalidRequest(perr))
 				return
-			} else {
-				captcha, err = m.Store.Get(uint32(id))
 			}
+			captcha, err = m.Store.Get(uint32(id))
 		} else {
 			render.Render(w, r, ErrNotFound)
 			return
@@ -152,93 +388,6 @@ func (m *Manager) CaptchaCtx(next http.Handler) http.Handler {
 	})
 }
 
-var (
-	templatesMu   sync.RWMutex
-	templates     map[string]*template.Template
-	templateFiles = map[string]struct{}{
-		"gotcha.min.js": {},
-		"gotcha.js":     {},
-	}
-)
-
-func getTemplate(filename string) (*template.Template, error) {
-	if _, ok := templateFiles[filename]; !ok {
-		return nil, fmt.Errorf("unknown template file")
-	}
-	if t, ok := templates[filename]; ok {
-		return t, nil
-	}
-
-	templatesMu.Lock()
-	defer templatesMu.Unlock()
-	b, err := Asset("static/js/" + filename)
-	if err != nil {
-		return nil, fmt.Errorf("error getting %q: %v", filename, err)
-	}
-	t, err := template.New(filename).Parse(string(b))
-	if err != nil {
-		return nil, fmt.Errorf("error parsing %q: %v", filename, err)
-	}
-	templates[filename] = t
-	return t, nil
-}
-
-// FileServer serve static files the chi way.
-func (m *Manager) fileServer(r chi.Router, fpath string, root http.FileSystem) {
-	if strings.ContainsAny(fpath, "{}*") {
-		panic("FileServer does not permit any URL parameters.")
-	}
-
-	if fpath != "/" && fpath[len(fpath)-1] != '/' {
-		r.Get(fpath, http.RedirectHandler(fpath+"/", 301).ServeHTTP)
-		fpath += "/"
-	}
-	fpath += "*"
-
-	if !m.noGzip {
-		r.Use(middleware.Compress(
-			5,
-			"text/html",
-			"text/css",
-			"text/plain",
-			"text/javascript",
-			"application/javascript",
-			"application/x-javascript",
-			"application/json",
-			"application/atom+xml",
-			"application/rss+xml",
-			"image/svg+xml",
-		))
-	}
-
-	r.Get(fpath, func(w http.ResponseWriter, r *http.Request) {
-		rctx := chi.RouteContext(r.Context())
-		pathPrefix := strings.TrimSuffix(rctx.RoutePattern(), "/*")
-		w.Header().Set("Cache-Control", "max-age:2592000, public")
-
-		pbase := path.Base(r.URL.Path)
-		if t, _ := getTemplate(pbase); t != nil {
-			var data = struct {
-				PublicURL string
-			}{
-				PublicURL: m.publicURL,
-			}
-			if err := t.Execute(w, data); err != nil {
-				http.Error(w, "error getting file", 500)
-				return
-			}
-			return
-		}
-
-		w.Header().Set(
-			"Content-Type",
-			storage.ResolveContentType(pbase),
-		)
-		fs := http.StripPrefix(pathPrefix, http.FileServer(root))
-		fs.ServeHTTP(w, r)
-	})
-}
-
 type ErrResponse struct {
 	Err            error `json:"-"` // low-level runtime error
 	HTTPStatusCode int   `json:"-"` // http response status code
@@ -261,6 +410,30 @@ func ErrInvalidRequest(err error) render.Renderer {
 	}
 }
 
+// ErrClient maps client errors to their response.
+func ErrClient(err error) render.Renderer {
+	switch {
+	case errors.Is(err, ErrClientRequired):
+		return ErrInvalidRequest(err)
+	case errors.Is(err, ErrClientNotFound),
+		errors.Is(err, ErrClientMismatch),
+		errors.Is(err, ErrOriginNotAllowed),
+		errors.Is(err, ErrLangNotAllowed):
+		return &ErrResponse{
+			Err:            err,
+			HTTPStatusCode: 403,
+			StatusText:     "Forbidden.",
+			ErrorText:      err.Error(),
+		}
+	}
+	return &ErrResponse{
+		Err:            err,
+		HTTPStatusCode: 500,
+		StatusText:     "Server error.",
+		ErrorText:      err.Error(),
+	}
+}
+
 func ErrRender(err error) render.Renderer {
 	return &ErrResponse{
 		Err:            err,
@@ -274,4 +447,11 @@ var (
 	ErrNotFound            = &ErrResponse{HTTPStatusCode: 404, StatusText: "Resource not found."}
 	ErrInternalServerError = &ErrResponse{HTTPStatusCode: 500, StatusText: "Server error."}
 	ErrIncorrectAnswer     = &ErrResponse{HTTPStatusCode: 403, StatusText: "Incorrect answer."}
+	ErrTooManyRequests     = &ErrResponse{HTTPStatusCode: 429, StatusText: "Too many requests."}
+	ErrUnavailable         = &ErrResponse{HTTPStatusCode: 503, StatusText: "Service unavailable."}
+	ErrAttemptsExceeded    = &ErrResponse{
+		HTTPStatusCode: 403,
+		StatusText:     "Too many attempts.",
+		ErrorText:      "the captcha needs to be refreshed",
+	}
 )
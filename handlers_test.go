@@ -0,0 +1,169 @@
+package gotcha
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCheckCaptchaAttempts(t *testing.T) {
+	store := &defaultStore{captchas: make(map[uint32]*Captcha)}
+	m := &Manager{
+		Store:               store,
+		mountpoint:          "/gotcha",
+		lifetimeAfterPassed: time.Minute,
+		maxAttempts:         3,
+	}
+	srv := httptest.NewServer(m.Router(context.Background()))
+	defer srv.Close()
+	store.Create(&Captcha{ID: 1, Answers: []string{"yes"}, Expiry: time.Now().Add(time.Minute)})
+
+	check := func(answer string) (int, string) {
+		body := `{"challenge-response": "` + answer + `"}`
+		res, err := http.Post(srv.URL+"/gotcha/1/check", "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		b, _ := ioutil.ReadAll(res.Body)
+		return res.StatusCode, string(b)
+	}
+
+	for i := 0; i < 2; i++ {
+		if code, body := check("no"); code != 403 || !strings.Contains(body, "Incorrect answer") {
+			t.Errorf("attempt %d: expected incorrect answer, got %d %s", i+1, code, body)
+		}
+	}
+	if code, body := check("no"); code != 403 || !strings.Contains(body, "Too many attempts") {
+		t.Errorf("expected last attempt to exhaust the captcha, got %d %s", code, body)
+	}
+	if code, body := check("yes"); code != 403 || !strings.Contains(body, "Too many attempts") {
+		t.Errorf("expected correct answer to be rejected, got %d %s", code, body)
+	}
+	if c, _ := store.Get(1); c.Passed || c.Attempts < 3 {
+		t.Errorf("expected at least 3 attempts and not passed, got %+v", c)
+	}
+}
+
+func TestCheckCaptchaParallel(t *testing.T) {
+	store := &defaultStore{captchas: make(map[uint32]*Captcha)}
+	m := &Manager{
+		Store:               store,
+		mountpoint:          "/gotcha",
+		lifetimeAfterPassed: time.Minute,
+		maxAttempts:         3,
+	}
+	srv := httptest.NewServer(m.Router(context.Background()))
+	defer srv.Close()
+	store.Create(&Captcha{ID: 1, Answers: []string{"yes"}, Expiry: time.Now().Add(time.Minute)})
+
+	var wg sync.WaitGroup
+	var incorrect int32
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			body := `{"challenge-response": "no"}`
+			res, err := http.Post(srv.URL+"/gotcha/1/check", "application/json", strings.NewReader(body))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer res.Body.Close()
+			if b, _ := ioutil.ReadAll(res.Body); strings.Contains(string(b), "Incorrect answer") {
+				atomic.AddInt32(&incorrect, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	if incorrect != 2 {
+		t.Errorf("expected 2 incorrect answers before the captcha is exhausted, got %d", incorrect)
+	}
+}
+
+func TestNewCaptchaMedia(t *testing.T) {
+	m := &Manager{
+		Languages:     []string{"en"},
+		Sources:       Random,
+		Store:         &defaultStore{captchas: make(map[uint32]*Captcha)},
+		FileStorage:   &memStorage{},
+		defaultExpiry: time.Minute,
+		mountpoint:    "/gotcha",
+		random:        RandomOptions{Alphabet: "ab", MinLength: 2, MaxLength: 2},
+	}
+	WithSpeaker(toneSpeaker{})(m)
+	srv := httptest.NewServer(m.Router(context.Background()))
+	defer srv.Close()
+
+	get := func(media string) (int, string) {
+		res, err := http.Get(srv.URL + "/gotcha/new?media=" + media)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		b, _ := ioutil.ReadAll(res.Body)
+		return res.StatusCode, string(b)
+	}
+	if code, body := get("audio"); code != 200 || strings.Contains(body, "image-url") || !strings.Contains(body, "audio-url") {
+		t.Errorf("expected an audio only captcha, got %d %s", code, body)
+	}
+	if code, body := get("image"); code != 200 || !strings.Contains(body, "image-url") || strings.Contains(body, "audio-url") {
+		t.Errorf("expected an image only captcha, got %d %s", code, body)
+	}
+	if code, body := get("video"); code != 400 {
+		t.Errorf("expected an invalid request, got %d %s", code, body)
+	}
+}
+
+func TestRefreshCaptchaMedia(t *testing.T) {
+	for _, stateless := range []bool{false, true} {
+		m := &Manager{
+			Languages:     []string{"en"},
+			Sources:       Random,
+			Store:         &defaultStore{captchas: make(map[uint32]*Captcha)},
+			FileStorage:   &memStorage{},
+			defaultExpiry: time.Minute,
+			mountpoint:    "/gotcha",
+			random:        RandomOptions{Alphabet: "ab", MinLength: 2, MaxLength: 2},
+		}
+		if stateless {
+			m.tokens = newTokenSigner(TokenKey{ID: "k", Secret: []byte("k-secret-k-secret-k-secret-k-sec")})
+		}
+		WithSpeaker(toneSpeaker{})(m)
+		srv := httptest.NewServer(m.Router(context.Background()))
+
+		c, err := m.Gen(context.WithValue(context.Background(), Media, MediaAudio))
+		if err != nil {
+			t.Fatal(err)
+		}
+		id := c.Token
+		if !stateless {
+			id = strconv.Itoa(int(c.ID))
+		}
+		// the media parameter is ignored, the refreshed challenge keeps its media
+		res, err := http.Post(srv.URL+"/gotcha/"+id+"/refresh?media=image", "application/json", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if body := string(b); res.StatusCode != 200 || strings.Contains(body, "image-url") || !strings.Contains(body, "audio-url") {
+			t.Errorf("stateless %v: expected an audio only captcha, got %d %s", stateless, res.StatusCode, body)
+		}
+		if stateless {
+			// the replaced token is spent
+			if err := m.tokens.check(c.Token, c.Answers[0], "", time.Minute, 0); !errors.Is(err, ErrTokenUsed) {
+				t.Errorf("expected the replaced token to be spent, got %v", err)
+			}
+		}
+		srv.Close()
+	}
+}
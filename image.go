@@ -0,0 +1,107 @@
+package gotcha
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/jpeg"
+	"image/png"
+
+	"github.com/djangulo/gotcha/draw"
+)
+
+// ErrImageTooLarge returned when a challenge image can't be encoded in
+// ImageOptions.MaxBytes.
+var ErrImageTooLarge = errors.New("image larger than max bytes")
+
+// ImageFormat encoding of the still challenge images.
+type ImageFormat uint8
+
+const (
+	// ImagePNG lossless PNG, the default.
+	ImagePNG ImageFormat = iota
+	// ImageJPEG lossy JPEG, see ImageOptions.Quality.
+	ImageJPEG
+	// ImageWebP lossless WebP.
+	ImageWebP
+)
+
+// ext file extension of images in f, ".png" for unknown formats, which are
+// encoded as PNG.
+func (f ImageFormat) ext() string {
+	switch f {
+	case ImageJPEG:
+		return ".jpg"
+	case ImageWebP:
+		return ".webp"
+	default:
+		return ".png"
+	}
+}
+
+// ImageOptions configures the challenge images, see WithImage.
+type ImageOptions struct {
+	// Format of the still images, animations are GIF or APNG regardless.
+	Format ImageFormat
+	// Quality of JPEG images, 1 to 100, defaults to jpeg.DefaultQuality.
+	Quality int
+	// Width and Height the images are scaled to fit in, keeping their aspect
+	// ratio, and padded to with their background. If only one is set the
+	// other follows the aspect ratio, if none the images keep the size they
+	// are drawn in.
+	Width  int
+	Height int
+	// MaxBytes upper bound of the size of the still images, 0 for no limit.
+	// JPEG quality is lowered down to 20 first, then the content of the
+	// images is scaled down, to no less than a quarter of its size, and
+	// padded with their background, until they fit. Challenges that still
+	// don't fit fail with ErrImageTooLarge.
+	MaxBytes int
+}
+
+const (
+	minJPEGQuality = 20
+	// minImageScale smallest scale MaxBytes shrinks images to.
+	minImageScale = 0.25
+)
+
+// encode encodes img as set by o, and returns its file name.
+func (o ImageOptions) encode(img image.Image) (data []byte, name string, err error) {
+	if o.Width > 0 || o.Height > 0 {
+		img = draw.Fit(img, o.Width, o.Height)
+	}
+	quality := o.Quality
+	if quality < 1 || quality > 100 {
+		quality = jpeg.DefaultQuality
+	}
+	orig, scale := img, 1.0
+	for {
+		var buf bytes.Buffer
+		switch o.Format {
+		case ImageJPEG:
+			err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality})
+		case ImageWebP:
+			err = draw.EncodeWebP(&buf, img)
+		default:
+			err = png.Encode(&buf, img)
+		}
+		if err != nil {
+			return nil, "", err
+		}
+		if o.MaxBytes <= 0 || buf.Len() <= o.MaxBytes {
+			return buf.Bytes(), "image" + o.Format.ext(), nil
+		}
+		if o.Format == ImageJPEG && quality > minJPEGQuality {
+			quality -= 10
+			if quality < minJPEGQuality {
+				quality = minJPEGQuality
+			}
+			continue
+		}
+		if scale *= 0.9; scale < minImageScale {
+			return nil, "", ErrImageTooLarge
+		}
+		// the image keeps its size, with its content scaled down
+		img = draw.Shrink(orig, scale)
+	}
+}
@@ -0,0 +1,76 @@
+package gotcha
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/jpeg"
+	"math/rand"
+	"testing"
+
+	"github.com/djangulo/gotcha/draw"
+	"golang.org/x/image/webp"
+)
+
+func TestImageOptions(t *testing.T) {
+	ctx := context.WithValue(context.Background(), draw.Rand, rand.New(rand.NewSource(1)))
+	img := draw.GenContext(ctx, "budget", draw.Inconsolata(ctx), draw.RandomLines(ctx))
+
+	data, name, err := ImageOptions{Format: ImageWebP, Width: 120, Height: 120}.encode(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "image.webp" {
+		t.Errorf("expected image.webp, got %s", name)
+	}
+	decoded, err := webp.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Bounds() != image.Rect(0, 0, 120, 120) {
+		t.Errorf("expected a 120x120 image, got %v", decoded.Bounds())
+	}
+
+	full, _, err := ImageOptions{Format: ImageJPEG, Quality: 95}.encode(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	budget := len(full) / 2
+	data, name, err = ImageOptions{Format: ImageJPEG, Quality: 95, MaxBytes: budget}.encode(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "image.jpg" || len(data) > budget {
+		t.Errorf("expected a jpg of at most %d bytes, got %s of %d", budget, name, len(data))
+	}
+	if _, err := jpeg.Decode(bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+
+	// past the lowest quality, the content shrinks within the same size
+	low, _, err := ImageOptions{Format: ImageJPEG, Quality: minJPEGQuality, Width: 200, Height: 100}.encode(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	budget = len(low) * 3 / 4
+	data, _, err = ImageOptions{Format: ImageJPEG, Width: 200, Height: 100, MaxBytes: budget}.encode(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) > budget {
+		t.Errorf("expected at most %d bytes, got %d", budget, len(data))
+	}
+	if decoded, err = jpeg.Decode(bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	} else if decoded.Bounds() != image.Rect(0, 0, 200, 100) {
+		t.Errorf("expected a 200x100 image, got %v", decoded.Bounds())
+	}
+
+	if _, name, err := (ImageOptions{Format: ImageWebP + 1}).encode(img); err != nil || name != "image.png" {
+		t.Errorf("expected unknown formats to be encoded as png, got %s, %v", name, err)
+	}
+
+	if _, _, err := (ImageOptions{MaxBytes: 100}).encode(img); err != ErrImageTooLarge {
+		t.Errorf("expected ErrImageTooLarge, got %v", err)
+	}
+}
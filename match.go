@@ -0,0 +1,185 @@
+package gotcha
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Normalizer transforms an answer in lang before it's compared.
+type Normalizer func(lang, s string) string
+
+// Matcher compares answers to a challenge after normalizing them.
+type Matcher struct {
+	// Normalizers applied in order to both the given and the expected
+	// answers.
+	Normalizers []Normalizer
+	// MaxDistance edit distance tolerated between the normalized answers, 0
+	// for exact matches. Ignored in stateless mode, where the expected answers
+	// are hashed.
+	MaxDistance int
+}
+
+// Normalize applies the normalizers of mt to s.
+func (mt *Matcher) Normalize(lang, s string) string {
+	for _, n := range mt.Normalizers {
+		s = n(lang, s)
+	}
+	return s
+}
+
+// Match reports whether ans matches any of answers.
+func (mt *Matcher) Match(lang, ans string, answers []string) bool {
+	ans = mt.Normalize(lang, ans)
+	for _, a := range answers {
+		a = mt.Normalize(lang, a)
+		if a == ans {
+			return true
+		}
+		if mt.MaxDistance > 0 && levenshtein(a, ans) <= mt.MaxDistance {
+			return true
+		}
+	}
+	return false
+}
+
+// DefaultMatcher returns the Matcher used for challenges from src, unless
+// overridden with WithMatcher. Numbers written in words are read from
+// symbols.
+func DefaultMatcher(src Source, symbols *Symbols) *Matcher {
+	if src == Random {
+		// random strings are case sensitive, unless
+		// RandomOptions.CaseInsensitive is set
+		return &Matcher{Normalizers: []Normalizer{NFKC, CollapseSpace}}
+	}
+	return &Matcher{Normalizers: []Normalizer{
+		NFKC,
+		FoldCase,
+		StripAccents,
+		CollapseSpace,
+		NumberWords(symbols),
+	}}
+}
+
+// NFKC normalizes s to its compatibility composition, e.g. full width
+// characters become ascii.
+func NFKC(lang, s string) string {
+	return norm.NFKC.String(s)
+}
+
+// FoldCase folds s for case insensitive comparison.
+func FoldCase(lang, s string) string {
+	return cases.Fold().String(s)
+}
+
+// keepMarks letters whose diacritics are significant in a language, and are
+// not stripped.
+var keepMarks = map[string]string{
+	"es": "ñÑ",
+}
+
+// StripAccents removes the diacritics of s, e.g. "bleué" becomes "bleue",
+// except for the letters where they are significant in lang.
+func StripAccents(lang, s string) string {
+	keep := keepMarks[lang]
+	var b strings.Builder
+	for _, r := range norm.NFC.String(s) {
+		if strings.ContainsRune(keep, r) {
+			b.WriteRune(r)
+			continue
+		}
+		for _, d := range norm.NFD.String(string(r)) {
+			if !unicode.Is(unicode.Mn, d) {
+				b.WriteRune(d)
+			}
+		}
+	}
+	return norm.NFC.String(b.String())
+}
+
+// CollapseSpace trims s and collapses runs of whitespace and dashes into a
+// single space, e.g. " sixty-three" becomes "sixty three".
+func CollapseSpace(lang, s string) string {
+	return strings.Join(strings.FieldsFunc(s, func(r rune) bool {
+		return unicode.IsSpace(r) || unicode.Is(unicode.Pd, r)
+	}), " ")
+}
+
+// NumberWords returns a Normalizer that replaces a number written in words
+// with its digits, as defined in symbols for each language, e.g.
+// "Sixty-three" becomes "63".
+func NumberWords(symbols *Symbols) Normalizer {
+	return func(lang, s string) string {
+		if symbols == nil {
+			return s
+		}
+		key := canonical(lang, s)
+		for _, sym := range symbols.Values[lang] {
+			if _, err := strconv.Atoi(sym.Symbol); err != nil {
+				continue
+			}
+			if canonical(lang, sym.Human) == key {
+				return sym.Symbol
+			}
+		}
+		return s
+	}
+}
+
+// canonical is the form number words are compared in.
+func canonical(lang, s string) string {
+	return CollapseSpace(lang, StripAccents(lang, FoldCase(lang, NFKC(lang, s))))
+}
+
+// levenshtein returns the edit distance between the runes of a and b.
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min3(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
+
+// Match reports whether ans is one of the answers of c, compared with the
+// Matcher for its source.
+func (m *Manager) Match(c *Captcha, ans string) bool {
+	return m.matcher(c.Source).Match(c.Lang, ans, c.Answers)
+}
+
+// matcher returns the Matcher for challenges from src.
+func (m *Manager) matcher(src Source) *Matcher {
+	if mt, ok := m.matchers[src]; ok {
+		return mt
+	}
+	mt := DefaultMatcher(src, m.Math)
+	if src == Random && m.random.CaseInsensitive {
+		mt.Normalizers = append(mt.Normalizers, FoldCase)
+	}
+	return mt
+}
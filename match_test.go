@@ -0,0 +1,61 @@
+package gotcha
+
+import "testing"
+
+func TestMatcher(t *testing.T) {
+	symbols := NewSymbols("en", "fr")
+	symbols.Values["en"] = []*MathSymbol{{Symbol: "63", Human: "sixty three"}}
+	symbols.Values["fr"] = []*MathSymbol{{Symbol: "8", Human: "huit"}}
+
+	for _, tc := range []struct {
+		name    string
+		src     Source
+		lang    string
+		ans     string
+		answers []string
+		want    bool
+	}{
+		{"case", QuestionBank, "en", "Red", []string{"red"}, true},
+		{"space", QuestionBank, "en", "  red ", []string{"red"}, true},
+		{"accents", QuestionBank, "fr", "BLEUÉ", []string{"bleue"}, true},
+		{"kept accent", QuestionBank, "es", "nino", []string{"niño"}, false},
+		{"full width", QuestionBank, "en", "ｒｅｄ", []string{"red"}, true},
+		{"number words", Math, "en", "Sixty-three", []string{"63"}, true},
+		{"digits for words", QuestionBank, "fr", "8", []string{"huit"}, true},
+		{"wrong", QuestionBank, "en", "blue", []string{"red"}, false},
+		{"random is case sensitive", Random, "en", "abc12x", []string{"aBc12X"}, false},
+		{"random trimmed", Random, "en", " aBc12X", []string{"aBc12X"}, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			mt := DefaultMatcher(tc.src, symbols)
+			if got := mt.Match(tc.lang, tc.ans, tc.answers); got != tc.want {
+				t.Errorf("Match(%q, %q) = %v, want %v", tc.ans, tc.answers, got, tc.want)
+			}
+		})
+	}
+
+	t.Run("edit distance", func(t *testing.T) {
+		mt := &Matcher{Normalizers: []Normalizer{FoldCase}, MaxDistance: 1}
+		if mt.Match("en", "Rde", []string{"red"}) {
+			t.Error("transposition needs 2 edits")
+		}
+		if !mt.Match("en", "rex", []string{"red"}) {
+			t.Error("expected substitution to match")
+		}
+	})
+}
+
+func TestManagerMatch(t *testing.T) {
+	c := &Captcha{Source: QuestionBank, Lang: "en", Answers: []string{"red"}}
+	if c.Match("Red") {
+		t.Error("expected Captcha.Match to be exact")
+	}
+	m := &Manager{}
+	if !m.Match(c, "Red") {
+		t.Error("expected the default matcher to fold case")
+	}
+	WithMatcher(QuestionBank, &Matcher{})(m)
+	if m.Match(c, "Red") {
+		t.Error("expected the manager's matcher to be used")
+	}
+}
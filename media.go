@@ -0,0 +1,153 @@
+package gotcha
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Expirer is implemented by Storers that can list their expired captchas.
+// Manager.GC uses it, when available, to remove the media of the captchas
+// the Storer's GC is about to delete.
+type Expirer interface {
+	Expired() ([]*Captcha, error)
+}
+
+// fileRoot is implemented by the go-storage drivers that keep their files on
+// the local filesystem, as fs does, the only ones SweepAssets can list.
+type fileRoot interface {
+	Root() string
+}
+
+// ContentTypeAdder is implemented by the storage drivers that can store a
+// file with an explicit Content-Type. Gen uses it, when available, to store
+// the challenge media with the MIME type of their encoder.
+type ContentTypeAdder interface {
+	AddFileWithContentType(r io.Reader, path, contentType string) (string, error)
+}
+
+// mediaPath returns the path in FileStorage, "<id>/<name>", of the media at
+// the URL u, or "" for inline media.
+func mediaPath(u string) string {
+	if u == "" || strings.HasPrefix(u, "data:") {
+		return ""
+	}
+	if p, err := url.Parse(u); err == nil {
+		u = p.Path
+	}
+	dir, name := path.Split(filepath.ToSlash(u))
+	return path.Join(path.Base(dir), name)
+}
+
+// removeMedia removes the image and audio of c from FileStorage, along with
+// their directory on local filesystem drivers. Missing files are not an
+// error.
+func (m *Manager) removeMedia(c *Captcha) error {
+	if m.FileStorage == nil {
+		return nil
+	}
+	var dir string
+	for _, u := range []string{c.Image, c.Audio} {
+		p := mediaPath(u)
+		if p == "" {
+			continue
+		}
+		if err := m.FileStorage.RemoveFile(p); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		dir = path.Dir(p)
+	}
+	if fr, ok := m.FileStorage.(fileRoot); ok && dir != "" {
+		// fails if the directory holds anything else
+		os.Remove(filepath.Join(fr.Root(), filepath.FromSlash(dir)))
+	}
+	return nil
+}
+
+// stampMedia sets the modification time of the media directory of c to its
+// expiry, for SweepAssets to keep it until then, however long the expiry.
+func (m *Manager) stampMedia(c *Captcha) {
+	fr, ok := m.FileStorage.(fileRoot)
+	if !ok {
+		return
+	}
+	for _, u := range []string{c.Image, c.Audio} {
+		if p := mediaPath(u); p != "" {
+			os.Chtimes(filepath.Join(fr.Root(), filepath.FromSlash(path.Dir(p))), c.Expiry, c.Expiry)
+			return
+		}
+	}
+}
+
+// gcStore garbage collects the Store, and removes the media of the captchas
+// it deletes if it is an Expirer.
+func (m *Manager) gcStore() error {
+	var expired []*Captcha
+	if e, ok := m.Store.(Expirer); ok {
+		var err error
+		if expired, err = e.Expired(); err != nil {
+			return err
+		}
+	}
+	if err := m.Store.GC(); err != nil {
+		return err
+	}
+	for _, c := range expired {
+		if err := m.removeMedia(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SweepAssets removes the asset directories of FileStorage whose IDs are not
+// in the Store: those of captchas deleted on being passed, expired in a
+// Storer without Expirer, or rendered but never stored. Directories are kept
+// until the default expiry after their modification time, which Gen stamps
+// with the expiry of the captcha, as they may belong to refreshed or
+// stateless captchas, which are stored under another ID or not at all, as
+// are those of the pooled challenges. It is a no-op unless FileStorage keeps
+// its files on the local filesystem, as go-storage cannot list files.
+func (m *Manager) SweepAssets() error {
+	if m.inline {
+		return nil
+	}
+	fr, ok := m.FileStorage.(fileRoot)
+	if !ok {
+		return nil
+	}
+	entries, err := ioutil.ReadDir(fr.Root())
+	if err != nil {
+		return err
+	}
+	cutoff := time.Now().Add(-m.defaultExpiry)
+	for _, e := range entries {
+		id, err := strconv.ParseUint(e.Name(), 10, 32)
+		if err != nil || !e.IsDir() || e.ModTime().After(cutoff) {
+			continue
+		}
+		if m.pool != nil && m.pool.holds(uint32(id)) {
+			continue
+		}
+		if m.tokens == nil {
+			_, err := m.Store.Get(uint32(id))
+			if err == nil {
+				continue
+			}
+			if !errors.Is(err, ErrCaptchaNotFound) {
+				return err
+			}
+		}
+		if err := os.RemoveAll(filepath.Join(fr.Root(), e.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
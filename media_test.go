@@ -0,0 +1,148 @@
+package gotcha
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	gostorage "github.com/djangulo/go-storage"
+	_ "github.com/djangulo/go-storage/providers/fs"
+)
+
+func TestMediaPath(t *testing.T) {
+	for _, tt := range []struct {
+		in, want string
+	}{
+		{"/assets/12/image.png", "12/image.png"},
+		{"https://cdn.example.com/gotcha/12/audio.wav?v=1", "12/audio.wav"},
+		{"data:image/png;base64,AAAA", ""},
+		{"", ""},
+	} {
+		if got := mediaPath(tt.in); got != tt.want {
+			t.Errorf("mediaPath(%q): expected %q got %q", tt.in, tt.want, got)
+		}
+	}
+}
+
+func TestAssetCleanup(t *testing.T) {
+	root, err := ioutil.TempDir("", "gotcha-assets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	fs, err := gostorage.Open("fs:///assets?root=" + root + "&accept=.png,.wav")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := &defaultStore{captchas: make(map[uint32]*Captcha)}
+	m := &Manager{
+		Languages:     []string{"en"},
+		Sources:       Random,
+		Store:         store,
+		FileStorage:   fs,
+		defaultExpiry: time.Minute,
+		random:        RandomOptions{Alphabet: "ab", MinLength: 2, MaxLength: 2},
+	}
+	WithSpeaker(toneSpeaker{})(m)
+	exists := func(name string) bool {
+		_, err := os.Stat(filepath.Join(root, name))
+		return err == nil
+	}
+	dir := func(c *Captcha) string {
+		return filepath.Dir(mediaPath(c.Image))
+	}
+	gen := func() *Captcha {
+		c, err := m.Gen(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+
+	c := gen()
+	old := dir(c)
+	if !exists(filepath.Join(old, "image.png")) || !exists(filepath.Join(old, "audio.wav")) {
+		t.Fatalf("expected the media of the captcha in %s", old)
+	}
+	c, err = m.Refresh(c.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exists(old) {
+		t.Error("expected refresh to remove the old media")
+	}
+	if !exists(dir(c)) {
+		t.Error("expected the media of the refreshed captcha")
+	}
+
+	c.Expiry = time.Now().Add(-time.Second)
+	store.Update(c.ID, c)
+	if err := m.gcStore(); err != nil {
+		t.Fatal(err)
+	}
+	if exists(dir(c)) {
+		t.Error("expected GC to remove the media of the expired captcha")
+	}
+
+	passed, live := gen(), gen()
+	store.Delete(passed.ID)
+	for _, name := range []string{"123", "not-an-id"} {
+		if err := os.Mkdir(filepath.Join(root, name), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	long := time.Now().Add(-2 * time.Minute)
+	for _, name := range []string{dir(passed), dir(live), "123", "not-an-id"} {
+		os.Chtimes(filepath.Join(root, name), long, long)
+	}
+	recent := gen()
+	store.Delete(recent.ID)
+	if err := m.SweepAssets(); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]bool{
+		dir(passed): false,
+		"123":       false,
+		dir(live):   true,
+		dir(recent): true,
+		"not-an-id": true,
+	} {
+		if got := exists(name); got != want {
+			t.Errorf("%s: expected exists %v, got %v", name, want, got)
+		}
+	}
+	if _, err := strconv.ParseUint(dir(recent), 10, 32); err != nil {
+		t.Errorf("expected the asset directory to be the captcha ID, got %s", dir(recent))
+	}
+
+	// stateless captchas are not in the Store, their media are kept until
+	// the default expiry after their own
+	m.tokens = newTokenSigner(TokenKey{ID: "k", Secret: []byte("k-secret-k-secret-k-secret-k-sec")})
+	c, err = m.Gen(context.WithValue(context.Background(), Expiry, time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(filepath.Join(root, dir(c)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := fi.ModTime().Sub(c.Expiry); d < -time.Second || d > time.Second {
+		t.Errorf("expected the directory to be stamped with the expiry %v, got %v", c.Expiry, fi.ModTime())
+	}
+	stale := gen()
+	past := time.Now().Add(-2 * time.Minute)
+	os.Chtimes(filepath.Join(root, dir(stale)), past, past)
+	if err := m.SweepAssets(); err != nil {
+		t.Fatal(err)
+	}
+	if !exists(dir(c)) {
+		t.Error("expected the media of the long lived stateless captcha to be kept")
+	}
+	if exists(dir(stale)) {
+		t.Error("expected the media of the expired stateless captcha to be removed")
+	}
+}
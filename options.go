@@ -4,6 +4,8 @@ import (
 	"time"
 
 	gostorage "github.com/djangulo/go-storage"
+
+	"github.com/djangulo/gotcha/draw"
 )
 
 type Option func(*Manager)
@@ -71,3 +73,186 @@ func WithQuestionBank(bank *Bank) Option {
 		m.Bank = bank
 	}
 }
+
+// WithStore sets the Store to the one opened from storeURL. See OpenStore for
+// viable connection strings. Panics on error.
+func WithStore(storeURL string) Option {
+	return func(m *Manager) {
+		store, err := OpenStore(storeURL)
+		if err != nil {
+			panic(err)
+		}
+		m.Store = store
+	}
+}
+
+// WithStatelessTokens replaces the Store with signed, expiring tokens. The
+// first key signs new tokens, all keys are accepted for verification, so
+// keys can be rotated by prepending a new one and dropping the oldest once
+// its tokens have expired. Panics if no keys are passed.
+func WithStatelessTokens(keys ...TokenKey) Option {
+	return func(m *Manager) {
+		if len(keys) == 0 {
+			panic("gotcha: WithStatelessTokens needs at least one key")
+		}
+		m.tokens = newTokenSigner(keys...)
+	}
+}
+
+// WithReplayStore records the stateless tokens in rs instead of in memory,
+// which gotcha instances sharing the token keys need to share, see
+// WithStatelessTokens.
+func WithReplayStore(rs ReplayStore) Option {
+	return func(m *Manager) {
+		m.replayStore = rs
+	}
+}
+
+// WithSecret sets the secret backends use to verify captchas in the
+// siteverify route. Verification is disabled if unset.
+func WithSecret(secret string) Option {
+	return func(m *Manager) {
+		m.secret = secret
+	}
+}
+
+// WithClients sets the client registry. Once set, every captcha is bound to
+// the client that requested it.
+func WithClients(clients ClientStorer) Option {
+	return func(m *Manager) {
+		m.Clients = clients
+	}
+}
+
+// WithMaxAttempts sets the number of wrong answers allowed per captcha, after
+// which it has to be refreshed. 0 allows unlimited attempts.
+func WithMaxAttempts(n int) Option {
+	return func(m *Manager) {
+		m.maxAttempts = n
+	}
+}
+
+// WithIPRateLimit limits the requests to the captcha routes per IP address.
+// See NewTokenBucket for an in-memory RateLimiter.
+func WithIPRateLimit(rl RateLimiter) Option {
+	return func(m *Manager) {
+		m.ipLimiter = rl
+	}
+}
+
+// WithClientRateLimit limits the requests to the captcha routes per client.
+// See NewTokenBucket for an in-memory RateLimiter.
+func WithClientRateLimit(rl RateLimiter) Option {
+	return func(m *Manager) {
+		m.clientLimiter = rl
+	}
+}
+
+// WithTrustProxy takes the client IP address from the X-Forwarded-For or
+// X-Real-IP headers. Only set it when running behind a proxy that sets them.
+func WithTrustProxy() Option {
+	return func(m *Manager) {
+		m.trustProxy = true
+	}
+}
+
+// WithPool keeps size pre-generated challenges ready per language and source,
+// refilled in the background by workers, so that requests don't wait for
+// them to render. Sources and languages added by clients are not pooled.
+// Call Manager.Close to stop the workers.
+func WithPool(size, workers int) Option {
+	return func(m *Manager) {
+		m.poolSize = size
+		m.poolWorkers = workers
+	}
+}
+
+// WithMatcher sets the Matcher used to check the answers to challenges from
+// src, which may combine several sources, e.g. Math|QuestionBank.
+func WithMatcher(src Source, mt *Matcher) Option {
+	return func(m *Manager) {
+		if m.matchers == nil {
+			m.matchers = make(map[Source]*Matcher)
+		}
+		for _, s := range []Source{Math, Random, QuestionBank} {
+			if src&s != 0 {
+				m.matchers[s] = mt
+			}
+		}
+	}
+}
+
+// WithRandom configures the Random source, see RandomOptions.
+func WithRandom(opts RandomOptions) Option {
+	return func(m *Manager) {
+		m.random = opts
+	}
+}
+
+// WithFonts draws the challenges with one of fonts, picked at random for
+// each, instead of Inconsolata. See draw.LoadFont and draw.ParseFont.
+func WithFonts(fonts ...*draw.Font) Option {
+	return func(m *Manager) {
+		m.fonts = fonts
+	}
+}
+
+// WithAnimation draws the challenges from src, which may combine several
+// sources, e.g. Math|QuestionBank, as animated GIFs, or APNGs, instead of
+// static PNGs. See Animation.
+func WithAnimation(src Source, a Animation) Option {
+	return func(m *Manager) {
+		if m.animations == nil {
+			m.animations = make(map[Source]Animation)
+		}
+		for _, s := range []Source{Math, Random, QuestionBank} {
+			if src&s != 0 {
+				m.animations[s] = a
+			}
+		}
+	}
+}
+
+// WithImage sets the format, size and size budget of the challenge images,
+// see ImageOptions.
+func WithImage(opts ImageOptions) Option {
+	return func(m *Manager) {
+		m.image = opts
+	}
+}
+
+// WithSpeaker reads the challenges with s, see ESpeak, SoundPack and
+// NoAudio. Defaults to DefaultSpeaker: espeak when gotcha is built with it,
+// the embedded sound pack otherwise, or NoAudio if there is none.
+func WithSpeaker(s Speaker) Option {
+	return func(m *Manager) {
+		m.tts = s
+	}
+}
+
+// WithAudio sets the noise, voice variation and pauses of the challenge
+// audio, see AudioOptions.
+func WithAudio(opts AudioOptions) Option {
+	return func(m *Manager) {
+		m.audio = opts
+	}
+}
+
+// WithAudioEncoder encodes the challenge audio with enc, WAV or FLAC, WAV by
+// default. FLAC needs inline media, the fs driver of go-storage, or a
+// ContentTypeAdder FileStorage: NewManager panics with the S3 and
+// DigitalOcean Spaces ones, which would label it text/html.
+func WithAudioEncoder(enc AudioEncoder) Option {
+	return func(m *Manager) {
+		m.audioEncoder = enc
+	}
+}
+
+// WithInlineMedia returns the challenge image and audio inline, as data URIs
+// in the image-url and audio-url fields, instead of storing them in
+// FileStorage, which is then not needed.
+func WithInlineMedia() Option {
+	return func(m *Manager) {
+		m.inline = true
+	}
+}
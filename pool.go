@@ -0,0 +1,226 @@
+package gotcha
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// poolRetryDelay is how long a worker waits before retrying a challenge that
+// failed to render.
+const poolRetryDelay = time.Second
+
+// PoolStats metrics of the pre-generated challenges of a language and source.
+type PoolStats struct {
+	Lang   string `json:"language"`
+	Source Source `json:"source"`
+	// Depth challenges ready to be served.
+	Depth int `json:"depth"`
+	// Size challenges the pool keeps ready when full.
+	Size int `json:"size"`
+	// Hits challenges served from the pool.
+	Hits uint64 `json:"hits"`
+	// Misses challenges requested while the pool was empty, which had to be
+	// rendered on request.
+	Misses uint64 `json:"misses"`
+	// Errors challenges that failed to render in the background.
+	Errors uint64 `json:"errors"`
+}
+
+type poolKey struct {
+	lang   string
+	source Source
+}
+
+type poolCounters struct {
+	hits, misses, errors uint64
+}
+
+// challengePool keeps up to size rendered challenges per language and
+// source. Every challenge taken from it is replaced in the background by one
+// of the workers.
+type challengePool struct {
+	m        *Manager
+	size     int
+	queues   map[poolKey]chan *Captcha
+	counters map[poolKey]*poolCounters
+	refill   chan poolKey
+	done     chan struct{}
+	wg       sync.WaitGroup
+
+	mu sync.Mutex
+	// held IDs of the challenges in the queues.
+	held map[uint32]struct{}
+}
+
+// newChallengePool starts workers filling size challenges for each of the
+// languages and sources of m.
+func newChallengePool(m *Manager, size, workers int) *challengePool {
+	if workers < 1 {
+		workers = 1
+	}
+	p := &challengePool{
+		m:        m,
+		size:     size,
+		queues:   make(map[poolKey]chan *Captcha),
+		counters: make(map[poolKey]*poolCounters),
+		done:     make(chan struct{}),
+		held:     make(map[uint32]struct{}),
+	}
+	for _, lang := range m.Languages {
+		for _, src := range []Source{Math, Random, QuestionBank} {
+			if m.Sources&src == 0 {
+				continue
+			}
+			if src == QuestionBank && (m.Bank == nil || len(m.Bank.Values[lang]) == 0) {
+				continue
+			}
+			key := poolKey{lang: lang, source: src}
+			p.queues[key] = make(chan *Captcha, size)
+			p.counters[key] = &poolCounters{}
+		}
+	}
+	// there is at most one pending refill per empty slot, so sending never
+	// blocks
+	p.refill = make(chan poolKey, len(p.queues)*size)
+	for key := range p.queues {
+		for i := 0; i < size; i++ {
+			p.refill <- key
+		}
+	}
+	p.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go p.work()
+	}
+	return p
+}
+
+func (p *challengePool) work() {
+	defer p.wg.Done()
+	for {
+		select {
+		case <-p.done:
+			return
+		case key := <-p.refill:
+			c, err := p.m.challenge(context.Background(), key.source, key.lang, p.m.defaultExpiry)
+			if err != nil {
+				atomic.AddUint64(&p.counters[key].errors, 1)
+				time.AfterFunc(poolRetryDelay, func() { p.requestRefill(key) })
+				continue
+			}
+			p.hold(c.ID, true)
+			select {
+			case p.queues[key] <- c:
+			default:
+				p.hold(c.ID, false)
+				p.m.removeMedia(c)
+			}
+		}
+	}
+}
+
+func (p *challengePool) requestRefill(key poolKey) {
+	select {
+	case p.refill <- key:
+	default:
+	}
+}
+
+// get returns a challenge in lang from src, or nil if there are none ready.
+func (p *challengePool) get(lang string, src Source) *Captcha {
+	key := poolKey{lang: lang, source: src}
+	q, ok := p.queues[key]
+	if !ok {
+		return nil
+	}
+	select {
+	case c := <-q:
+		p.hold(c.ID, false)
+		atomic.AddUint64(&p.counters[key].hits, 1)
+		p.requestRefill(key)
+		return c
+	default:
+		atomic.AddUint64(&p.counters[key].misses, 1)
+		return nil
+	}
+}
+
+// hold records whether the challenge under id is in the queues.
+func (p *challengePool) hold(id uint32, held bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if held {
+		p.held[id] = struct{}{}
+	} else {
+		delete(p.held, id)
+	}
+}
+
+// holds reports whether the challenge under id is in the queues.
+func (p *challengePool) holds(id uint32) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, ok := p.held[id]
+	return ok
+}
+
+func (p *challengePool) stats() []PoolStats {
+	stats := make([]PoolStats, 0, len(p.queues))
+	for key, q := range p.queues {
+		cnt := p.counters[key]
+		stats = append(stats, PoolStats{
+			Lang:   key.lang,
+			Source: key.source,
+			Depth:  len(q),
+			Size:   p.size,
+			Hits:   atomic.LoadUint64(&cnt.hits),
+			Misses: atomic.LoadUint64(&cnt.misses),
+			Errors: atomic.LoadUint64(&cnt.errors),
+		})
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Lang != stats[j].Lang {
+			return stats[i].Lang < stats[j].Lang
+		}
+		return stats[i].Source < stats[j].Source
+	})
+	return stats
+}
+
+// stop stops the workers and waits for them to return.
+func (p *challengePool) stop() {
+	close(p.done)
+	p.wg.Wait()
+}
+
+// drain removes the challenges left in the queues, and their media.
+func (p *challengePool) drain() {
+	for _, q := range p.queues {
+		for len(q) > 0 {
+			c := <-q
+			p.hold(c.ID, false)
+			p.m.removeMedia(c)
+		}
+	}
+}
+
+// PoolStats returns the metrics of the challenge pool, sorted by language and
+// source. Returns nil if the pool is disabled, see WithPool.
+func (m *Manager) PoolStats() []PoolStats {
+	if m.pool == nil {
+		return nil
+	}
+	return m.pool.stats()
+}
+
+// Close stops the background workers of the manager.
+func (m *Manager) Close() error {
+	if m.pool != nil {
+		m.pool.stop()
+		m.pool.drain()
+		m.pool = nil
+	}
+	return nil
+}
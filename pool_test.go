@@ -0,0 +1,100 @@
+package gotcha
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	gostorage "github.com/djangulo/go-storage"
+)
+
+// memStorage is an in-memory gostorage.Driver.
+type memStorage struct {
+	sync.Mutex
+	files map[string][]byte
+}
+
+func (ms *memStorage) Open(string) (gostorage.Driver, error) { return ms, nil }
+func (ms *memStorage) Close() error                          { return nil }
+func (ms *memStorage) Accepts(string) bool                   { return true }
+func (ms *memStorage) Path() string                          { return "/assets" }
+func (ms *memStorage) NormalizePath(entries ...string) string {
+	return filepath.Join(append([]string{ms.Path()}, entries...)...)
+}
+
+func (ms *memStorage) AddFile(r io.Reader, path string) (string, error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return "", err
+	}
+	ms.Lock()
+	defer ms.Unlock()
+	if ms.files == nil {
+		ms.files = make(map[string][]byte)
+	}
+	ms.files[path] = b
+	return ms.NormalizePath(path), nil
+}
+
+func (ms *memStorage) GetFile(path string) (io.ReadCloser, error) {
+	ms.Lock()
+	defer ms.Unlock()
+	b, ok := ms.files[path]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return ioutil.NopCloser(bytes.NewReader(b)), nil
+}
+
+func (ms *memStorage) RemoveFile(path string) error {
+	ms.Lock()
+	defer ms.Unlock()
+	delete(ms.files, path)
+	return nil
+}
+
+func TestPool(t *testing.T) {
+	m := &Manager{
+		Languages:     []string{"en"},
+		Sources:       Random,
+		Store:         &defaultStore{captchas: make(map[uint32]*Captcha)},
+		FileStorage:   &memStorage{},
+		defaultExpiry: time.Minute,
+	}
+	m.pool = newChallengePool(m, 2, 2)
+	deadline := time.Now().Add(10 * time.Second)
+	for m.PoolStats()[0].Depth < 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("pool not filled: %+v", m.PoolStats())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	// stop the workers so that the pool is not refilled
+	m.pool.stop()
+
+	ids := make(map[uint32]bool)
+	for i := 0; i < 3; i++ {
+		c, err := m.Gen(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ids[c.ID] {
+			t.Errorf("duplicate id %d", c.ID)
+		}
+		ids[c.ID] = true
+		if _, err := m.Store.Get(c.ID); err != nil {
+			t.Errorf("captcha %d not stored: %v", c.ID, err)
+		}
+	}
+	stats := m.PoolStats()
+	want := PoolStats{Lang: "en", Source: Random, Depth: 0, Size: 2, Hits: 2, Misses: 1}
+	if len(stats) != 1 || stats[0] != want {
+		t.Errorf("expected stats %+v, got %+v", want, stats)
+	}
+}
@@ -0,0 +1,126 @@
+package gotcha
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-chi/render"
+)
+
+// RateLimiter limits the rate of events per key. Implementations backed by
+// a shared store (e.g. redis) allow limiting across gotcha instances.
+type RateLimiter interface {
+	// Allow consumes an event for key. If it's not allowed, retryAfter is
+	// how long until it would be.
+	Allow(key string) (ok bool, retryAfter time.Duration, err error)
+}
+
+// TokenBucket is an in-memory RateLimiter. Each key gets a bucket of burst
+// tokens, refilled at rate tokens per second.
+type TokenBucket struct {
+	sync.Mutex
+	rate    float64
+	burst   float64
+	buckets map[string]*bucket
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// NewTokenBucket returns a *TokenBucket allowing rate events per second per
+// key, with bursts of up to burst events. rate must be positive.
+func NewTokenBucket(rate float64, burst int) *TokenBucket {
+	return &TokenBucket{
+		rate:    rate,
+		burst:   float64(burst),
+		buckets: make(map[string]*bucket),
+	}
+}
+
+func (tb *TokenBucket) Allow(key string) (bool, time.Duration, error) {
+	tb.Lock()
+	defer tb.Unlock()
+	now := time.Now()
+	b, ok := tb.buckets[key]
+	if !ok {
+		b = &bucket{tokens: tb.burst, last: now}
+		tb.buckets[key] = b
+	}
+	b.tokens = math.Min(tb.burst, b.tokens+now.Sub(b.last).Seconds()*tb.rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0, nil
+	}
+	wait := time.Duration((1 - b.tokens) / tb.rate * float64(time.Second))
+	return false, wait, nil
+}
+
+// GC removes the buckets that have refilled completely, as they are
+// equivalent to new ones.
+func (tb *TokenBucket) GC() {
+	tb.Lock()
+	defer tb.Unlock()
+	now := time.Now()
+	for key, b := range tb.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*tb.rate >= tb.burst {
+			delete(tb.buckets, key)
+		}
+	}
+}
+
+// RateLimit is a middleware that limits requests per IP address. Rejected
+// requests get a 429 with a Retry-After header. The per client limit is
+// applied by the handlers, see allowClient.
+func (m *Manager) RateLimit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if m.ipLimiter != nil {
+			if !m.allow(w, r, m.ipLimiter, "ip:"+remoteIP(r)) {
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// allowClient limits the requests per client, once clientContext or
+// checkClient has validated clientID, so that it cannot be spoofed. Requests
+// without a client are only limited per IP address.
+func (m *Manager) allowClient(w http.ResponseWriter, r *http.Request, clientID string) bool {
+	if clientID == "" || m.clientLimiter == nil {
+		return true
+	}
+	return m.allow(w, r, m.clientLimiter, "client:"+clientID)
+}
+
+// allow renders the rejection if key is not allowed by rl.
+func (m *Manager) allow(w http.ResponseWriter, r *http.Request, rl RateLimiter, key string) bool {
+	ok, retryAfter, err := rl.Allow(key)
+	if err != nil {
+		render.Render(w, r, ErrInternalServerError)
+		return false
+	}
+	if !ok {
+		secs := int(math.Ceil(retryAfter.Seconds()))
+		if secs < 1 {
+			secs = 1
+		}
+		w.Header().Set("Retry-After", strconv.Itoa(secs))
+		render.Render(w, r, ErrTooManyRequests)
+		return false
+	}
+	return true
+}
+
+func remoteIP(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
@@ -0,0 +1,78 @@
+package gotcha
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	tb := NewTokenBucket(10, 2)
+	for i := 0; i < 2; i++ {
+		if ok, _, _ := tb.Allow("a"); !ok {
+			t.Fatalf("expected burst event %d to be allowed", i+1)
+		}
+	}
+	ok, retryAfter, _ := tb.Allow("a")
+	if ok {
+		t.Fatal("expected event over burst to be rejected")
+	}
+	if retryAfter <= 0 || retryAfter > 100*time.Millisecond {
+		t.Errorf("expected retry after in (0, 100ms] got %v", retryAfter)
+	}
+	if ok, _, _ := tb.Allow("b"); !ok {
+		t.Error("expected keys to have their own bucket")
+	}
+	time.Sleep(retryAfter)
+	if ok, _, _ := tb.Allow("a"); !ok {
+		t.Error("expected bucket to refill")
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	store := &defaultStore{captchas: make(map[uint32]*Captcha)}
+	m := &Manager{
+		Store:         store,
+		Clients:       NewClientStore(&Client{SiteKey: "a", SecretKey: "a-secret"}),
+		mountpoint:    "/gotcha",
+		maxAttempts:   10,
+		ipLimiter:     NewTokenBucket(0.01, 4),
+		clientLimiter: NewTokenBucket(0.01, 1),
+	}
+	srv := httptest.NewServer(m.Router(context.Background()))
+	defer srv.Close()
+	store.Create(&Captcha{ID: 1, ClientID: "a", Answers: []string{"yes"}, Expiry: time.Now().Add(time.Minute)})
+
+	post := func(path, clientID string) *http.Response {
+		body := `{"challenge-response": "no", "client-id": "` + clientID + `"}`
+		res, err := http.Post(srv.URL+path, "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		return res
+	}
+	// the query parameter is not the validated client
+	if res := post("/gotcha/1/check?client-id=a", ""); res.StatusCode != 400 {
+		t.Errorf("expected 400 got %d", res.StatusCode)
+	}
+	if res := post("/gotcha/1/check", "a"); res.StatusCode != 403 {
+		t.Errorf("expected 403 got %d", res.StatusCode)
+	}
+	if res := post("/gotcha/1/check", "a"); res.StatusCode != 429 {
+		t.Errorf("expected client limit to apply, got %d", res.StatusCode)
+	}
+	if res := post("/gotcha/1/check", ""); res.StatusCode != 400 {
+		t.Errorf("expected 400 got %d", res.StatusCode)
+	}
+	res := post("/gotcha/1/check", "")
+	if res.StatusCode != 429 {
+		t.Fatalf("expected ip limit to apply, got %d", res.StatusCode)
+	}
+	if res.Header.Get("Retry-After") == "" {
+		t.Error("expected a Retry-After header")
+	}
+}
@@ -0,0 +1,284 @@
+package gotcha
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// RedisStore is a Storer backed by redis, so that multiple gotcha instances
+// can share captchas. Keys are set to expire at Captcha.Expiry, so GC is a
+// no-op.
+type RedisStore struct {
+	client redis.UniversalClient
+	prefix string
+}
+
+// NewRedisStore returns a *RedisStore using client. All keys are prefixed
+// with prefix, defaults to "gotcha:".
+func NewRedisStore(client redis.UniversalClient, prefix string) *RedisStore {
+	if prefix == "" {
+		prefix = "gotcha:"
+	}
+	return &RedisStore{client: client, prefix: prefix}
+}
+
+// OpenRedisStore opens a *RedisStore from a url of the form
+// redis://[:password@]host:port/db. See redis.ParseURL for details.
+func OpenRedisStore(url string) (*RedisStore, error) {
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, err
+	}
+	client := redis.NewClient(opts)
+	if err := client.Ping(context.Background()).Err(); err != nil {
+		return nil, err
+	}
+	return NewRedisStore(client, ""), nil
+}
+
+func (rs *RedisStore) key(id uint32) string {
+	return rs.prefix + strconv.FormatUint(uint64(id), 10)
+}
+
+// ttl returns the time left until c expires. Redis rejects non-positive
+// expirations, so an already expired captcha is given the minimum.
+func ttl(c *Captcha) time.Duration {
+	return ttlUntil(c.Expiry)
+}
+
+func ttlUntil(t time.Time) time.Duration {
+	d := time.Until(t)
+	if d < time.Millisecond {
+		d = time.Millisecond
+	}
+	return d
+}
+
+func (rs *RedisStore) Create(c *Captcha) error {
+	b, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	return rs.client.Set(context.Background(), rs.key(c.ID), b, ttl(c)).Err()
+}
+
+func (rs *RedisStore) Get(id uint32) (*Captcha, error) {
+	b, err := rs.client.Get(context.Background(), rs.key(id)).Bytes()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, ErrCaptchaNotFound
+		}
+		return nil, err
+	}
+	var c Captcha
+	if err := json.Unmarshal(b, &c); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// Update replaces the captcha under id with c, and resets its TTL to
+// c.Expiry. Returns ErrCaptchaNotFound if id does not exist (or expired).
+func (rs *RedisStore) Update(id uint32, c *Captcha) error {
+	b, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	ok, err := rs.client.SetXX(context.Background(), rs.key(id), b, ttl(c)).Result()
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrCaptchaNotFound
+	}
+	return nil
+}
+
+func (rs *RedisStore) Delete(id uint32) error {
+	return rs.client.Del(context.Background(), rs.key(id)).Err()
+}
+
+// IncrAttempts atomically increments the attempts of the captcha under id,
+// retrying if the captcha is modified concurrently.
+func (rs *RedisStore) IncrAttempts(id uint32) (int, error) {
+	ctx := context.Background()
+	key := rs.key(id)
+	var n int
+	incr := func(tx *redis.Tx) error {
+		b, err := tx.Get(ctx, key).Bytes()
+		if err != nil {
+			if errors.Is(err, redis.Nil) {
+				return ErrCaptchaNotFound
+			}
+			return err
+		}
+		var c Captcha
+		if err := json.Unmarshal(b, &c); err != nil {
+			return err
+		}
+		c.Attempts++
+		n = c.Attempts
+		if b, err = json.Marshal(&c); err != nil {
+			return err
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.SetXX(ctx, key, b, ttl(&c))
+			return nil
+		})
+		return err
+	}
+	for i := 0; i < 10; i++ {
+		err := rs.client.Watch(ctx, incr, key)
+		if errors.Is(err, redis.TxFailedErr) {
+			continue
+		}
+		return n, err
+	}
+	return 0, redis.TxFailedErr
+}
+
+// Take atomically gets and deletes the captcha under id.
+func (rs *RedisStore) Take(id uint32) (*Captcha, error) {
+	ctx := context.Background()
+	var get *redis.StringCmd
+	_, err := rs.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		get = pipe.Get(ctx, rs.key(id))
+		pipe.Del(ctx, rs.key(id))
+		return nil
+	})
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return nil, err
+	}
+	b, err := get.Bytes()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, ErrCaptchaNotFound
+		}
+		return nil, err
+	}
+	var c Captcha
+	if err := json.Unmarshal(b, &c); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// GC is a no-op, redis expires the keys on its own.
+func (rs *RedisStore) GC() error {
+	return nil
+}
+
+// RedisReplayStore is a ReplayStore backed by redis, so that gotcha instances
+// sharing their token keys know the tokens passed on any of them. Keys are
+// set to expire with their tokens.
+type RedisReplayStore struct {
+	client redis.UniversalClient
+	prefix string
+}
+
+// NewRedisReplayStore returns a *RedisReplayStore using client. All keys are
+// prefixed with prefix, defaults to "gotcha:replay:".
+func NewRedisReplayStore(client redis.UniversalClient, prefix string) *RedisReplayStore {
+	if prefix == "" {
+		prefix = "gotcha:replay:"
+	}
+	return &RedisReplayStore{client: client, prefix: prefix}
+}
+
+// key of field of token, tokens are hashed as they are long.
+func (rs *RedisReplayStore) key(token, field string) string {
+	sum := sha256.Sum256([]byte(token))
+	return rs.prefix + hex.EncodeToString(sum[:]) + ":" + field
+}
+
+// redisPass value of the passed key of a token, with a zero PassedAt if the
+// token was spent.
+type redisPass struct {
+	PassedAt time.Time `json:"passed_at"`
+	ValidTil time.Time `json:"valid_til"`
+	Hostname string    `json:"hostname,omitempty"`
+}
+
+func (rs *RedisReplayStore) Attempt(token string, expiry time.Time) (int, error) {
+	ctx := context.Background()
+	key := rs.key(token, "attempts")
+	var incr *redis.IntCmd
+	_, err := rs.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		incr = pipe.Incr(ctx, key)
+		pipe.PExpire(ctx, key, ttlUntil(expiry))
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return int(incr.Val()), nil
+}
+
+func (rs *RedisReplayStore) Pass(token string, expiry time.Time, hostname string, lifetime time.Duration) error {
+	now := time.Now()
+	p := redisPass{PassedAt: now, ValidTil: now.Add(lifetime), Hostname: hostname}
+	// keep it around for as long as the token can be replayed
+	if p.ValidTil.After(expiry) {
+		expiry = p.ValidTil
+	}
+	return rs.setPass(token, expiry, &p)
+}
+
+func (rs *RedisReplayStore) Spend(token string, expiry time.Time) error {
+	err := rs.setPass(token, expiry, &redisPass{})
+	if errors.Is(err, ErrTokenUsed) {
+		return nil
+	}
+	return err
+}
+
+// setPass sets the passed key of token to p, unless it is set. Returns
+// ErrTokenUsed if it is.
+func (rs *RedisReplayStore) setPass(token string, expiry time.Time, p *redisPass) error {
+	b, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	ok, err := rs.client.SetNX(context.Background(), rs.key(token, "passed"), b, ttlUntil(expiry)).Result()
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrTokenUsed
+	}
+	return nil
+}
+
+func (rs *RedisReplayStore) Consume(token string) (time.Time, string, error) {
+	ctx := context.Background()
+	b, err := rs.client.Get(ctx, rs.key(token, "passed")).Bytes()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return time.Time{}, "", ErrNotPassed
+		}
+		return time.Time{}, "", err
+	}
+	var p redisPass
+	if err := json.Unmarshal(b, &p); err != nil {
+		return time.Time{}, "", err
+	}
+	if p.PassedAt.IsZero() || time.Now().After(p.ValidTil) {
+		return time.Time{}, "", ErrNotPassed
+	}
+	// past ValidTil the token is not passed anymore, consumed or not
+	ok, err := rs.client.SetNX(ctx, rs.key(token, "consumed"), 1, ttlUntil(p.ValidTil)).Result()
+	if err != nil {
+		return time.Time{}, "", err
+	}
+	if !ok {
+		return time.Time{}, "", ErrTokenUsed
+	}
+	return p.PassedAt, p.Hostname, nil
+}
@@ -0,0 +1,98 @@
+package gotcha
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+func TestRedisStore(t *testing.T) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mr.Close()
+	store := NewRedisStore(redis.NewClient(&redis.Options{Addr: mr.Addr()}), "")
+
+	c := &Captcha{
+		ID:      42,
+		Lang:    "en",
+		Answers: []string{"4", "four"},
+		Expiry:  time.Now().Add(time.Minute),
+	}
+	if err := store.Create(c); err != nil {
+		t.Fatal(err)
+	}
+	got, err := store.Get(c.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Match("four") {
+		t.Errorf("expected answers %v got %v", c.Answers, got.Answers)
+	}
+	if ttl := mr.TTL("gotcha:42"); ttl <= 0 || ttl > time.Minute {
+		t.Errorf("expected ttl in (0, 1m] got %v", ttl)
+	}
+
+	for i := 1; i <= 2; i++ {
+		n, err := store.IncrAttempts(c.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != i {
+			t.Errorf("expected %d attempts got %d", i, n)
+		}
+	}
+	if ttl := mr.TTL("gotcha:42"); ttl <= 0 {
+		t.Errorf("expected ttl to be kept on IncrAttempts got %v", ttl)
+	}
+
+	got.Passed = true
+	got.Expiry = time.Now().Add(10 * time.Second)
+	if err := store.Update(got.ID, got); err != nil {
+		t.Fatal(err)
+	}
+	if ttl := mr.TTL("gotcha:42"); ttl > 10*time.Second {
+		t.Errorf("expected ttl to be reset to <= 10s got %v", ttl)
+	}
+
+	mr.FastForward(11 * time.Second)
+	if _, err := store.Get(c.ID); !errors.Is(err, ErrCaptchaNotFound) {
+		t.Errorf("expected %v got %v", ErrCaptchaNotFound, err)
+	}
+	if err := store.Update(c.ID, c); !errors.Is(err, ErrCaptchaNotFound) {
+		t.Errorf("expected %v on update of expired captcha got %v", ErrCaptchaNotFound, err)
+	}
+
+	if err := store.Create(c); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Take(c.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Take(c.ID); !errors.Is(err, ErrCaptchaNotFound) {
+		t.Errorf("expected %v got %v", ErrCaptchaNotFound, err)
+	}
+
+	if err := store.Create(c); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Delete(c.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Get(c.ID); !errors.Is(err, ErrCaptchaNotFound) {
+		t.Errorf("expected %v got %v", ErrCaptchaNotFound, err)
+	}
+}
+
+func TestRedisReplayStore(t *testing.T) {
+	mr, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mr.Close()
+	testReplayStore(t, NewRedisReplayStore(redis.NewClient(&redis.Options{Addr: mr.Addr()}), ""))
+}
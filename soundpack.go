@@ -0,0 +1,371 @@
+package gotcha
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// ErrNoClip the sound pack has no clip for a word or character of the text,
+// nor for its language.
+var ErrNoClip = errors.New("missing sound pack clip")
+
+// SoundPack Speaker that reads text by concatenating pre-recorded clips of
+// its words, or of their characters when a word has no clip of its own. It
+// needs neither cgo nor espeak. The clips are 16 bit mono WAV files, one
+// directory per language, see Manager.RecordSoundPack. Languages without a
+// directory of their own are read with the clips of the "und" (undetermined)
+// one, if the pack has it, see Manager.RecordSpelledSoundPack.
+type SoundPack struct {
+	// Gap silence between clips, defaults to 150ms.
+	Gap time.Duration
+
+	read  func(name string) ([]byte, error)
+	index soundPackIndex
+	mu    sync.Mutex
+	// clips decoded so far, nil for the missing ones.
+	clips map[string][]int16
+}
+
+// soundPackIndex index.json of a sound pack.
+type soundPackIndex struct {
+	SampleRate int      `json:"sample_rate"`
+	Languages  []string `json:"languages"`
+}
+
+// NewSoundPack opens the sound pack recorded in dir.
+func NewSoundPack(dir string) (*SoundPack, error) {
+	return newSoundPack(func(name string) ([]byte, error) {
+		return ioutil.ReadFile(filepath.Join(dir, filepath.FromSlash(name)))
+	})
+}
+
+// EmbeddedSoundPack opens the sound pack embedded from static/sounds, see
+// the sounds and bindata make targets.
+func EmbeddedSoundPack() (*SoundPack, error) {
+	return newSoundPack(func(name string) ([]byte, error) {
+		return Asset(path.Join("static", "sounds", name))
+	})
+}
+
+func newSoundPack(read func(name string) ([]byte, error)) (*SoundPack, error) {
+	data, err := read("index.json")
+	if err != nil {
+		return nil, err
+	}
+	p := &SoundPack{Gap: 150 * time.Millisecond, read: read, clips: make(map[string][]int16)}
+	if err := json.Unmarshal(data, &p.index); err != nil {
+		return nil, err
+	}
+	if p.index.SampleRate <= 0 {
+		return nil, errors.New("sound pack index has no sample rate")
+	}
+	return p, nil
+}
+
+// Speak implements Speaker.
+func (p *SoundPack) Speak(ctx context.Context, text, lang string) ([]int16, int, error) {
+	lang, err := p.language(lang)
+	if err != nil {
+		return nil, 0, err
+	}
+	gap := make([]int16, int(p.Gap.Seconds()*float64(p.index.SampleRate)))
+	var out []int16
+	for _, word := range words(text) {
+		clips, err := p.word(lang, word)
+		if err != nil {
+			return nil, 0, err
+		}
+		for _, c := range clips {
+			if len(out) > 0 {
+				out = append(out, gap...)
+			}
+			out = append(out, c...)
+		}
+	}
+	if len(out) == 0 {
+		return nil, 0, fmt.Errorf("%w: nothing to read in %q", ErrNoClip, text)
+	}
+	return out, p.index.SampleRate, nil
+}
+
+// anyLanguage BCP 47 tag of the clips that read any language.
+const anyLanguage = "und"
+
+// language returns the language of the pack that matches lang, either
+// exactly or by its base language, "en" for "en-US", or else anyLanguage.
+func (p *SoundPack) language(lang string) (string, error) {
+	lang = strings.ToLower(lang)
+	base := strings.FieldsFunc(lang, func(r rune) bool { return r == '-' || r == '_' })
+	for _, l := range p.index.Languages {
+		if strings.ToLower(l) == lang {
+			return l, nil
+		}
+	}
+	for _, l := range p.index.Languages {
+		if len(base) > 0 && strings.ToLower(l) == base[0] {
+			return l, nil
+		}
+	}
+	for _, l := range p.index.Languages {
+		if l == anyLanguage {
+			return l, nil
+		}
+	}
+	return "", fmt.Errorf("%w: no clips in %s", ErrNoClip, lang)
+}
+
+// word returns the clip of word, or the clips of its characters. Punctuation
+// without clips, as the math symbols have, is not read.
+func (p *SoundPack) word(lang, word string) ([][]int16, error) {
+	c, err := p.clip(lang, word)
+	if err != nil || c != nil {
+		return [][]int16{c}, err
+	}
+	if isPunct(word) {
+		return nil, nil
+	}
+	var clips [][]int16
+	for _, r := range word {
+		c, err := p.clip(lang, string(r))
+		if err != nil {
+			return nil, err
+		}
+		if c == nil {
+			return nil, fmt.Errorf("%w: %q in %s", ErrNoClip, r, lang)
+		}
+		clips = append(clips, c)
+	}
+	return clips, nil
+}
+
+// clip returns the clip of token, nil if the pack has none.
+func (p *SoundPack) clip(lang, token string) ([]int16, error) {
+	name := path.Join(lang, clipName(token)+".wav")
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if c, ok := p.clips[name]; ok {
+		return c, nil
+	}
+	data, err := p.read(name)
+	if err != nil {
+		p.clips[name] = nil
+		return nil, nil
+	}
+	samples, rate, err := readWAV(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	if rate != p.index.SampleRate {
+		return nil, fmt.Errorf("%s: sample rate %d, expected %d", name, rate, p.index.SampleRate)
+	}
+	p.clips[name] = samples
+	return samples, nil
+}
+
+// clipName file name, without extension, of the clip of token.
+func clipName(token string) string {
+	return url.PathEscape(strings.ToLower(token))
+}
+
+// words splits text in the words clips are recorded for, without the
+// punctuation around them.
+func words(text string) []string {
+	var ws []string
+	for _, f := range strings.Fields(text) {
+		if w := strings.TrimFunc(f, unicode.IsPunct); w != "" {
+			f = w
+		}
+		ws = append(ws, f)
+	}
+	return ws
+}
+
+func isPunct(word string) bool {
+	return strings.TrimFunc(word, unicode.IsPunct) == ""
+}
+
+// readWAV decodes a 16 bit mono PCM WAV file.
+func readWAV(data []byte) (samples []int16, sampleRate int, err error) {
+	if len(data) < 12 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WAVE" {
+		return nil, 0, errors.New("not a WAV file")
+	}
+	data = data[12:]
+	var hasFormat bool
+	for len(data) >= 8 {
+		id, size := string(data[:4]), int(binary.LittleEndian.Uint32(data[4:8]))
+		data = data[8:]
+		if size > len(data) {
+			size = len(data)
+		}
+		chunk := data[:size]
+		switch id {
+		case "fmt ":
+			if len(chunk) < 16 {
+				return nil, 0, errors.New("short WAV format chunk")
+			}
+			format := binary.LittleEndian.Uint16(chunk[0:])
+			channels := binary.LittleEndian.Uint16(chunk[2:])
+			bits := binary.LittleEndian.Uint16(chunk[14:])
+			if format != 1 || channels != 1 || bits != 16 {
+				return nil, 0, errors.New("WAV file is not 16 bit mono PCM")
+			}
+			sampleRate = int(binary.LittleEndian.Uint32(chunk[4:]))
+			hasFormat = true
+		case "data":
+			if !hasFormat {
+				return nil, 0, errors.New("WAV data before its format")
+			}
+			samples = make([]int16, len(chunk)/2)
+			for i := range samples {
+				samples[i] = int16(binary.LittleEndian.Uint16(chunk[2*i:]))
+			}
+			return samples, sampleRate, nil
+		}
+		// chunks are padded to an even size
+		if size%2 == 1 && size < len(data) {
+			size++
+		}
+		data = data[size:]
+	}
+	return nil, 0, errors.New("WAV file has no data")
+}
+
+// RecordSoundPack records with s a sound pack for the challenges of m into
+// dir, in each of its languages: the characters of the Random alphabet, the
+// digits, the math symbols and the words of the math symbols and of the
+// question bank.
+func (m *Manager) RecordSoundPack(s Speaker, dir string) error {
+	index := soundPackIndex{Languages: m.Languages}
+	for _, lang := range m.Languages {
+		if err := recordClips(s, dir, lang, m.soundPackTexts(lang), &index); err != nil {
+			return err
+		}
+	}
+	return writeSoundPackIndex(dir, index)
+}
+
+// RecordSpelledSoundPack records with s a language independent sound pack
+// into dir, which reads every language spelling its words: a clip for each
+// character of the challenges of m, in the "und" language. For speakers that
+// spell anyway, as Morse code does, where the clips of whole words would only
+// repeat those of their characters.
+func (m *Manager) RecordSpelledSoundPack(s Speaker, dir string) error {
+	texts := make(map[string]string)
+	for _, lang := range m.Languages {
+		for name, text := range m.soundPackTexts(lang) {
+			// single characters, the math symbols among them, read themselves
+			if token, err := url.PathUnescape(name); err == nil && len([]rune(token)) == 1 {
+				texts[name] = token
+			}
+			for _, r := range strings.ToLower(text) {
+				if !unicode.IsSpace(r) {
+					texts[clipName(string(r))] = string(r)
+				}
+			}
+		}
+	}
+	index := soundPackIndex{Languages: []string{anyLanguage}}
+	if err := recordClips(s, dir, anyLanguage, texts, &index); err != nil {
+		return err
+	}
+	return writeSoundPackIndex(dir, index)
+}
+
+// soundPackTexts returns the texts read in the clips of lang, by clip name.
+func (m *Manager) soundPackTexts(lang string) map[string]string {
+	alphabet := m.random.Alphabet
+	if alphabet == "" {
+		alphabet = UnambiguousAlphabet
+	}
+	texts := make(map[string]string)
+	for _, r := range strings.ToLower(alphabet) + "0123456789" {
+		texts[clipName(string(r))] = string(r)
+	}
+	if m.Math != nil {
+		for _, sym := range m.Math.Values[lang] {
+			texts[clipName(sym.Symbol)] = sym.Human
+			for _, w := range words(sym.Human) {
+				texts[clipName(w)] = w
+			}
+		}
+	}
+	if m.Bank != nil {
+		for _, c := range m.Bank.Values[lang] {
+			for _, w := range words(c.Question) {
+				if !isPunct(w) {
+					texts[clipName(w)] = w
+				}
+			}
+		}
+	}
+	return texts
+}
+
+// recordClips records with s the clips of texts, by clip name, into the
+// directory of lang, setting the sample rate of index.
+func recordClips(s Speaker, dir, lang string, texts map[string]string, index *soundPackIndex) error {
+	if err := os.MkdirAll(filepath.Join(dir, lang), 0755); err != nil {
+		return err
+	}
+	for name, text := range texts {
+		samples, rate, err := s.Speak(context.Background(), text, lang)
+		if err != nil {
+			return fmt.Errorf("recording %q in %s: %w", text, lang, err)
+		}
+		if len(samples) == 0 {
+			return fmt.Errorf("recording %q in %s: no audio", text, lang)
+		}
+		if index.SampleRate == 0 {
+			index.SampleRate = rate
+		} else if rate != index.SampleRate {
+			return fmt.Errorf("recording %q in %s: sample rate %d, expected %d", text, lang, rate, index.SampleRate)
+		}
+		var buf bytes.Buffer
+		if err := WAV.Encode(&buf, trimSilence(samples), rate); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, lang, name+".wav"), buf.Bytes(), 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeSoundPackIndex(dir string, index soundPackIndex) error {
+	data, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, "index.json"), data, 0644)
+}
+
+// trimSilence cuts the near silent samples off both ends of samples, Gap
+// sets the silence between clips instead.
+func trimSilence(samples []int16) []int16 {
+	const threshold = 256
+	loud := func(s int16) bool { return s > threshold || s < -threshold }
+	start, end := 0, len(samples)
+	for start < end && !loud(samples[start]) {
+		start++
+	}
+	for end > start && !loud(samples[end-1]) {
+		end--
+	}
+	if start == end {
+		return samples
+	}
+	return samples[start:end]
+}
@@ -0,0 +1,149 @@
+package gotcha
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+// toneSpeaker reads 100 samples per character.
+type toneSpeaker struct{}
+
+func (toneSpeaker) Speak(ctx context.Context, text, lang string) ([]int16, int, error) {
+	samples := make([]int16, 100*len([]rune(text)))
+	for i := range samples {
+		samples[i] = 1000
+	}
+	return samples, 8000, nil
+}
+
+func TestSoundPack(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gotcha-sounds")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	m := &Manager{
+		Languages: []string{"en"},
+		Math: &Symbols{Values: map[string][]*MathSymbol{"en": {
+			{Human: "plus", Symbol: "+"},
+			{Human: "six", Symbol: "6"},
+			{Human: "divided by", Symbol: "÷"},
+		}}},
+		Bank:   &Bank{Values: map[string][]*Captcha{"en": {{Question: "What color is the sky?"}}}},
+		random: RandomOptions{Alphabet: "abc"},
+	}
+	if err := m.RecordSoundPack(toneSpeaker{}, dir); err != nil {
+		t.Fatal(err)
+	}
+	p, err := NewSoundPack(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 80 samples at 8kHz
+	p.Gap = 10 * time.Millisecond
+
+	for _, tt := range []struct {
+		text, lang string
+		// texts read in each clip
+		clips []string
+	}{
+		{"six + 6", "en", []string{"six", "plus", "six"}},
+		{"6 ÷ six", "en-US", []string{"six", "divided by", "six"}},
+		{"cab", "en", []string{"c", "a", "b"}},
+		{"What color is the sky ?", "en", []string{"what", "color", "is", "the", "sky"}},
+	} {
+		t.Run(tt.text, func(t *testing.T) {
+			samples, rate, err := p.Speak(context.Background(), tt.text, tt.lang)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rate != 8000 {
+				t.Errorf("expected a sample rate of 8000, got %d", rate)
+			}
+			want := 80 * (len(tt.clips) - 1)
+			for _, c := range tt.clips {
+				want += 100 * len([]rune(c))
+			}
+			if len(samples) != want {
+				t.Errorf("expected %d samples, got %d", want, len(samples))
+			}
+		})
+	}
+
+	for _, tt := range []struct{ text, lang string }{
+		{"xyz", "en"},
+		{"six", "de"},
+	} {
+		if _, _, err := p.Speak(context.Background(), tt.text, tt.lang); !errors.Is(err, ErrNoClip) {
+			t.Errorf("%q in %s: expected ErrNoClip, got %v", tt.text, tt.lang, err)
+		}
+	}
+}
+
+func TestSpelledSoundPack(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gotcha-sounds")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	m := &Manager{
+		Languages: []string{"en", "es"},
+		Math: &Symbols{Values: map[string][]*MathSymbol{
+			"en": {{Human: "plus", Symbol: "+"}},
+			"es": {{Human: "más", Symbol: "+"}},
+		}},
+		random: RandomOptions{Alphabet: "abc"},
+	}
+	if err := m.RecordSpelledSoundPack(toneSpeaker{}, dir); err != nil {
+		t.Fatal(err)
+	}
+	p, err := NewSoundPack(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Gap = 10 * time.Millisecond
+	// any language is spelled, the symbols read themselves
+	for _, lang := range []string{"en", "es", "de"} {
+		samples, _, err := p.Speak(context.Background(), "más + 6", lang)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := 5*100 + 4*80; len(samples) != want {
+			t.Errorf("%s: expected %d samples, got %d", lang, want, len(samples))
+		}
+	}
+}
+
+func TestDefaultSpeaker(t *testing.T) {
+	s, err := DefaultSpeaker()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ESpeak(); err != nil {
+		// static builds read with the embedded sound pack
+		if _, ok := s.(*SoundPack); !ok {
+			t.Fatalf("expected the embedded sound pack, got %T", s)
+		}
+	}
+	m := NewManager()
+	for _, lang := range m.Languages {
+		texts := []string{"4 + 7", "9 × 3", "8 ÷ 2", "6 - 5", "a7kq"}
+		for _, c := range m.Bank.Values[lang] {
+			texts = append(texts, c.Question)
+		}
+		for _, text := range texts {
+			samples, _, err := s.Speak(context.Background(), text, lang)
+			if err != nil {
+				t.Errorf("%q in %s: %v", text, lang, err)
+			} else if len(samples) == 0 {
+				t.Errorf("%q in %s: no audio", text, lang)
+			}
+		}
+	}
+}
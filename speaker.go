@@ -0,0 +1,66 @@
+package gotcha
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// ErrNoESpeak returned by ESpeak when gotcha is built without cgo, or with
+// the noespeak build tag.
+var ErrNoESpeak = errors.New("built without espeak")
+
+// Speaker reads the challenges out loud.
+type Speaker interface {
+	// Speak returns text read in lang as 16 bit mono samples, and their
+	// sample rate. Speakers without audio return no samples and a nil error.
+	Speak(ctx context.Context, text, lang string) (samples []int16, sampleRate int, err error)
+}
+
+// NoAudio Speaker for challenges without audio.
+var NoAudio Speaker = noAudio{}
+
+type noAudio struct{}
+
+func (noAudio) Speak(ctx context.Context, text, lang string) ([]int16, int, error) {
+	return nil, 0, nil
+}
+
+// ErrNoSpeaker returned by DefaultSpeaker when gotcha is built without
+// espeak, and without an embedded sound pack.
+var ErrNoSpeaker = errors.New("built without espeak nor an embedded sound pack")
+
+var defaultSpeaker struct {
+	once sync.Once
+	s    Speaker
+	err  error
+}
+
+// DefaultSpeaker returns espeak if gotcha is built with it, or the embedded
+// sound pack if it has clips, which reads in Morse code unless one is recorded
+// with the sounds make target. Otherwise, if static/sounds was not embedded
+// with the bindata make target, it returns NoAudio and ErrNoSpeaker.
+func DefaultSpeaker() (Speaker, error) {
+	defaultSpeaker.once.Do(func() {
+		if s, err := ESpeak(); err == nil {
+			defaultSpeaker.s = s
+			return
+		}
+		if s, err := EmbeddedSoundPack(); err == nil {
+			defaultSpeaker.s = s
+			return
+		}
+		defaultSpeaker.s, defaultSpeaker.err = NoAudio, ErrNoSpeaker
+	})
+	return defaultSpeaker.s, defaultSpeaker.err
+}
+
+// speaker returns the Speaker set with WithSpeaker, or DefaultSpeaker, which
+// may be NoAudio.
+func (m *Manager) speaker() Speaker {
+	if m.tts != nil {
+		return m.tts
+	}
+	s, _ := DefaultSpeaker()
+	return s
+}
@@ -0,0 +1,36 @@
+//go:build cgo && !noespeak
+// +build cgo,!noespeak
+
+package gotcha
+
+import (
+	"context"
+
+	espeak "github.com/djangulo/go-espeak"
+)
+
+// ESpeak returns the Speaker that reads the challenges with espeak, in the
+// voice that best matches their language. VoiceVariant picks the voices
+// after it, alternating male and female ones.
+func ESpeak() (Speaker, error) {
+	return espeakSpeaker{}, nil
+}
+
+type espeakSpeaker struct{}
+
+func (espeakSpeaker) Speak(ctx context.Context, text, lang string) ([]int16, int, error) {
+	spec := &espeak.Voice{Languages: lang}
+	if n, ok := ctx.Value(VoiceVariant).(int); ok && n > 0 {
+		spec.Gender = espeak.Male + espeak.Gender(n%2)
+		spec.Variant = espeak.Variant(n / 2)
+	}
+	v, err := espeak.VoiceFromSpec(spec)
+	if err != nil {
+		return nil, 0, err
+	}
+	samples, err := espeak.GenSamples(text, v, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	return samples, int(espeak.SampleRate()), nil
+}
@@ -0,0 +1,10 @@
+//go:build !cgo || noespeak
+// +build !cgo noespeak
+
+package gotcha
+
+// ESpeak returns ErrNoESpeak, gotcha was built without cgo or with the
+// noespeak build tag.
+func ESpeak() (Speaker, error) {
+	return nil, ErrNoESpeak
+}
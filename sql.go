@@ -0,0 +1,338 @@
+package gotcha
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	// database drivers, sqlite3 is in sql_sqlite.go
+	_ "github.com/lib/pq"
+)
+
+// sqlMigrations are applied in order, the schema version being index+1.
+// Append new migrations at the end, never modify existing ones.
+var sqlMigrations = []string{
+	`CREATE TABLE IF NOT EXISTS gotcha_captchas (
+	id BIGINT PRIMARY KEY,
+	image TEXT NOT NULL DEFAULT '',
+	audio TEXT NOT NULL DEFAULT '',
+	question TEXT NOT NULL DEFAULT '',
+	answers TEXT NOT NULL DEFAULT '[]',
+	lang VARCHAR(35) NOT NULL DEFAULT '',
+	client_id VARCHAR(255) NOT NULL DEFAULT '',
+	passed BOOLEAN NOT NULL DEFAULT FALSE,
+	expiry TIMESTAMP NOT NULL
+)`,
+	`CREATE INDEX IF NOT EXISTS gotcha_captchas_expiry_idx ON gotcha_captchas (expiry)`,
+	`ALTER TABLE gotcha_captchas ADD COLUMN hostname VARCHAR(255) NOT NULL DEFAULT ''`,
+	`ALTER TABLE gotcha_captchas ADD COLUMN passed_at TIMESTAMP NULL`,
+	`ALTER TABLE gotcha_captchas ADD COLUMN attempts INTEGER NOT NULL DEFAULT 0`,
+	`ALTER TABLE gotcha_captchas ADD COLUMN source INTEGER NOT NULL DEFAULT 0`,
+	`ALTER TABLE gotcha_captchas ADD COLUMN consumed_at TIMESTAMP NULL`,
+}
+
+// sqlColumns of gotcha_captchas, in the order of captchaArgs and scanCaptcha.
+const sqlColumns = `id, image, audio, question, answers, lang, client_id, passed, expiry,
+	hostname, passed_at, attempts, source`
+
+// SQLStore is a database/sql backed Storer. Supported drivers are "sqlite3"
+// and "postgres".
+//
+// Take marks captchas as consumed instead of deleting them, and GC keeps the
+// consumed captchas for Retention after their expiry, for auditing. Consumed
+// captchas are otherwise not found, as deleted ones.
+type SQLStore struct {
+	// Retention of the consumed captchas after their expiry. Zero keeps them
+	// until deleted.
+	Retention time.Duration
+	db        *sql.DB
+	driver    string
+}
+
+// NewSQLStore returns a *SQLStore using db, and runs any pending migrations.
+// driver is the name db was opened with.
+func NewSQLStore(db *sql.DB, driver string) (*SQLStore, error) {
+	switch driver {
+	case "sqlite3", "postgres":
+	default:
+		return nil, fmt.Errorf("unsupported sql driver %q", driver)
+	}
+	s := &SQLStore{db: db, driver: driver}
+	if err := s.Migrate(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// OpenSQLStore opens a *SQLStore with driver and dsn.
+func OpenSQLStore(driver, dsn string) (*SQLStore, error) {
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	if driver == "sqlite3" {
+		// sqlite serializes writes anyway, and each connection to :memory:
+		// would otherwise get its own database.
+		db.SetMaxOpenConns(1)
+	}
+	if err := db.Ping(); err != nil {
+		return nil, err
+	}
+	return NewSQLStore(db, driver)
+}
+
+// Migrate applies the migrations newer than the current schema version.
+func (s *SQLStore) Migrate() error {
+	_, err := s.db.Exec(`CREATE TABLE IF NOT EXISTS gotcha_migrations (
+	version INTEGER PRIMARY KEY
+)`)
+	if err != nil {
+		return err
+	}
+	var version int
+	err = s.db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM gotcha_migrations`).Scan(&version)
+	if err != nil {
+		return err
+	}
+	for i := version; i < len(sqlMigrations); i++ {
+		tx, err := s.db.Begin()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(sqlMigrations[i]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d: %w", i+1, err)
+		}
+		if _, err := tx.Exec(s.rebind(`INSERT INTO gotcha_migrations (version) VALUES (?)`), i+1); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d: %w", i+1, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rebind replaces the ? placeholders in query with the driver's.
+func (s *SQLStore) rebind(query string) string {
+	if s.driver != "postgres" {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// captchaArgs returns the values of c in the order of sqlColumns.
+func captchaArgs(c *Captcha) ([]interface{}, error) {
+	answers, err := json.Marshal(c.Answers)
+	if err != nil {
+		return nil, err
+	}
+	passedAt := sql.NullTime{Time: c.PassedAt.UTC(), Valid: !c.PassedAt.IsZero()}
+	return []interface{}{
+		int64(c.ID),
+		c.Image,
+		c.Audio,
+		c.Question,
+		string(answers),
+		c.Lang,
+		c.ClientID,
+		c.Passed,
+		c.Expiry.UTC(),
+		c.Hostname,
+		passedAt,
+		c.Attempts,
+		int(c.Source),
+	}, nil
+}
+
+// scanCaptcha scans a row selected with sqlColumns.
+func scanCaptcha(row interface{ Scan(...interface{}) error }) (*Captcha, error) {
+	var (
+		c        Captcha
+		id       int64
+		answers  string
+		passedAt sql.NullTime
+	)
+	err := row.Scan(
+		&id,
+		&c.Image,
+		&c.Audio,
+		&c.Question,
+		&answers,
+		&c.Lang,
+		&c.ClientID,
+		&c.Passed,
+		&c.Expiry,
+		&c.Hostname,
+		&passedAt,
+		&c.Attempts,
+		&c.Source,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrCaptchaNotFound
+		}
+		return nil, err
+	}
+	c.ID = uint32(id)
+	c.PassedAt = passedAt.Time
+	if err := json.Unmarshal([]byte(answers), &c.Answers); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func (s *SQLStore) Create(c *Captcha) error {
+	args, err := captchaArgs(c)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(s.rebind(`INSERT INTO gotcha_captchas (`+sqlColumns+`)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`), args...)
+	return err
+}
+
+func (s *SQLStore) Get(id uint32) (*Captcha, error) {
+	return scanCaptcha(s.db.QueryRow(s.rebind(`SELECT `+sqlColumns+`
+	FROM gotcha_captchas WHERE id = ? AND consumed_at IS NULL`), int64(id)))
+}
+
+// Update replaces the captcha under id with c. Returns ErrCaptchaNotFound if
+// id does not exist or was consumed.
+func (s *SQLStore) Update(id uint32, c *Captcha) error {
+	args, err := captchaArgs(c)
+	if err != nil {
+		return err
+	}
+	// the id column is not updated
+	args = append(args[1:], int64(id))
+	res, err := s.db.Exec(s.rebind(`UPDATE gotcha_captchas SET
+	image = ?, audio = ?, question = ?, answers = ?, lang = ?, client_id = ?,
+	passed = ?, expiry = ?, hostname = ?, passed_at = ?, attempts = ?,
+	source = ?
+	WHERE id = ? AND consumed_at IS NULL`), args...)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrCaptchaNotFound
+	}
+	return nil
+}
+
+// IncrAttempts atomically increments the attempts of the captcha under id.
+func (s *SQLStore) IncrAttempts(id uint32) (int, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	res, err := tx.Exec(s.rebind(`UPDATE gotcha_captchas SET attempts = attempts + 1
+	WHERE id = ? AND consumed_at IS NULL`), int64(id))
+	if err != nil {
+		return 0, err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return 0, err
+	} else if n == 0 {
+		return 0, ErrCaptchaNotFound
+	}
+	var n int
+	err = tx.QueryRow(s.rebind(`SELECT attempts FROM gotcha_captchas WHERE id = ?`), int64(id)).Scan(&n)
+	if err != nil {
+		return 0, err
+	}
+	return n, tx.Commit()
+}
+
+// Take atomically gets the captcha under id and marks it as consumed. If two
+// transactions race, only the one that marks the row gets the captcha.
+func (s *SQLStore) Take(id uint32) (*Captcha, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	c, err := scanCaptcha(tx.QueryRow(s.rebind(`SELECT `+sqlColumns+`
+	FROM gotcha_captchas WHERE id = ? AND consumed_at IS NULL`), int64(id)))
+	if err != nil {
+		return nil, err
+	}
+	res, err := tx.Exec(s.rebind(`UPDATE gotcha_captchas SET consumed_at = ?
+	WHERE id = ? AND consumed_at IS NULL`), time.Now().UTC(), int64(id))
+	if err != nil {
+		return nil, err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return nil, err
+	} else if n == 0 {
+		return nil, ErrCaptchaNotFound
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (s *SQLStore) Delete(id uint32) error {
+	_, err := s.db.Exec(s.rebind(`DELETE FROM gotcha_captchas WHERE id = ?`), int64(id))
+	return err
+}
+
+// gcWhere selects the rows GC deletes: the expired captchas never consumed,
+// and the consumed ones past Retention.
+const gcWhere = `(expiry < ? AND consumed_at IS NULL) OR (expiry < ? AND consumed_at IS NOT NULL)`
+
+// gcArgs returns the arguments of gcWhere.
+func (s *SQLStore) gcArgs() []interface{} {
+	now := time.Now().UTC()
+	retained := time.Time{}
+	if s.Retention > 0 {
+		retained = now.Add(-s.Retention)
+	}
+	return []interface{}{now, retained}
+}
+
+// Expired returns the captchas GC is about to delete.
+func (s *SQLStore) Expired() ([]*Captcha, error) {
+	rows, err := s.db.Query(s.rebind(`SELECT `+sqlColumns+`
+	FROM gotcha_captchas WHERE `+gcWhere), s.gcArgs()...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var expired []*Captcha
+	for rows.Next() {
+		c, err := scanCaptcha(rows)
+		if err != nil {
+			return nil, err
+		}
+		expired = append(expired, c)
+	}
+	return expired, rows.Err()
+}
+
+// GC deletes the expired captchas, keeping the consumed ones for Retention.
+func (s *SQLStore) GC() error {
+	_, err := s.db.Exec(s.rebind(`DELETE FROM gotcha_captchas WHERE `+gcWhere), s.gcArgs()...)
+	return err
+}
@@ -0,0 +1,7 @@
+//go:build cgo && !nosqlite
+// +build cgo,!nosqlite
+
+package gotcha
+
+// the sqlite3 driver needs cgo, builds without it only support postgres
+import _ "github.com/mattn/go-sqlite3"
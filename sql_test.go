@@ -0,0 +1,150 @@
+package gotcha
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSQLStore(t *testing.T) {
+	var sqlite bool
+	for _, d := range sql.Drivers() {
+		sqlite = sqlite || d == "sqlite3"
+	}
+	if !sqlite {
+		t.Skip("built without the sqlite3 driver")
+	}
+	store, err := OpenSQLStore("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// migrations are idempotent
+	if err := store.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+
+	c := &Captcha{
+		ID:       4294967295,
+		Lang:     "en",
+		ClientID: "client",
+		Question: "2 + two",
+		Answers:  []string{"4", "four"},
+		Expiry:   time.Now().Add(time.Minute),
+	}
+	if err := store.Create(c); err != nil {
+		t.Fatal(err)
+	}
+	got, err := store.Get(c.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != c.ID || got.ClientID != c.ClientID || got.Lang != c.Lang || !got.Match("four") {
+		t.Errorf("expected %+v got %+v", c, got)
+	}
+	if !got.Expiry.Equal(c.Expiry) {
+		t.Errorf("expected expiry %v got %v", c.Expiry, got.Expiry)
+	}
+
+	for i := 1; i <= 2; i++ {
+		n, err := store.IncrAttempts(c.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != i {
+			t.Errorf("expected %d attempts got %d", i, n)
+		}
+	}
+	if _, err := store.IncrAttempts(1); !errors.Is(err, ErrCaptchaNotFound) {
+		t.Errorf("expected %v got %v", ErrCaptchaNotFound, err)
+	}
+
+	got.Passed = true
+	if err := store.Update(got.ID, got); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ = store.Get(c.ID); !got.Passed {
+		t.Errorf("expected captcha to be passed")
+	}
+	if err := store.Update(1, got); !errors.Is(err, ErrCaptchaNotFound) {
+		t.Errorf("expected %v got %v", ErrCaptchaNotFound, err)
+	}
+
+	expired := &Captcha{ID: 1, Expiry: time.Now().Add(-time.Second)}
+	if err := store.Create(expired); err != nil {
+		t.Fatal(err)
+	}
+	if list, err := store.Expired(); err != nil || len(list) != 1 || list[0].ID != expired.ID {
+		t.Errorf("expected the expired captcha to be listed, got %v %v", list, err)
+	}
+	if err := store.GC(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Get(expired.ID); !errors.Is(err, ErrCaptchaNotFound) {
+		t.Errorf("expected expired captcha to be collected, got %v", err)
+	}
+	if _, err := store.Get(c.ID); err != nil {
+		t.Errorf("expected unexpired captcha to survive GC, got %v", err)
+	}
+
+	taken, err := store.Take(c.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !taken.Passed {
+		t.Errorf("expected taken captcha to be passed")
+	}
+	if _, err := store.Take(c.ID); !errors.Is(err, ErrCaptchaNotFound) {
+		t.Errorf("expected %v got %v", ErrCaptchaNotFound, err)
+	}
+	if _, err := store.Get(c.ID); !errors.Is(err, ErrCaptchaNotFound) {
+		t.Errorf("expected %v got %v", ErrCaptchaNotFound, err)
+	}
+
+	// consumed captchas are kept for auditing, until past Retention
+	consumed := &Captcha{ID: 2, Passed: true, Expiry: time.Now().Add(-time.Hour)}
+	if err := store.Create(consumed); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Take(consumed.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.GC(); err != nil {
+		t.Fatal(err)
+	}
+	var n int
+	count := `SELECT COUNT(*) FROM gotcha_captchas WHERE consumed_at IS NOT NULL`
+	if err := store.db.QueryRow(count).Scan(&n); err != nil || n != 2 {
+		t.Errorf("expected 2 consumed captchas kept, got %d %v", n, err)
+	}
+	store.Retention = 30 * time.Minute
+	if list, err := store.Expired(); err != nil || len(list) != 1 || list[0].ID != consumed.ID {
+		t.Errorf("expected the consumed captcha past retention to be listed, got %v %v", list, err)
+	}
+	if err := store.GC(); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.db.QueryRow(count).Scan(&n); err != nil || n != 1 {
+		t.Errorf("expected 1 consumed captcha kept, got %d %v", n, err)
+	}
+
+	c.ID = 3
+	if err := store.Create(c); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Delete(c.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Get(c.ID); !errors.Is(err, ErrCaptchaNotFound) {
+		t.Errorf("expected %v got %v", ErrCaptchaNotFound, err)
+	}
+}
+
+func TestSQLStoreRebind(t *testing.T) {
+	s := &SQLStore{driver: "postgres"}
+	got := s.rebind(`UPDATE t SET a = ?, b = ? WHERE id = ?`)
+	want := `UPDATE t SET a = $1, b = $2 WHERE id = $3`
+	if got != want {
+		t.Errorf("expected %q got %q", want, got)
+	}
+}
@@ -32,11 +32,12 @@ var Gotcha = {
     })
   },
   refresh: function() {
-    fetch(`{{.PublicURL}}/gotcha/refresh`, {
+    fetch(`{{.PublicURL}}/gotcha/${this.id}/refresh`, {
       method: 'POST',
-      body: {
-        id: this.id
-      }
+      headers: {"Content-Type": "application/json"},
+      body: JSON.stringify({
+        "client-id": this.clientId
+      })
     }).then(function(response) {
       return response.json()
     }).then(function(data) {
@@ -52,13 +53,12 @@ var Gotcha = {
     });
     div.appendChild(createElement('img', {
       "id": "gotcha-challenge-image",
-      "src": "{{.PublicURL}}/gotcha/assets/"+this.id+"/image.png",
+      "src": this.imageURL,
       "alt": "gotcha captcha challenge image",
     }));
     div.appendChild(createElement('audio', {
       "id": "gotcha-challenge-audio",
-      "src": "{{.PublicURL}}/gotcha/assets/"+this.id+"/audio.wav",
-      "type": "audio/wav"
+      "src": this.audioURL
     }));
     div.appendChild(createElement('input', {
       "id": "gotcha-challenge-response",
@@ -0,0 +1,50 @@
+package gotcha
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrCaptchaNotFound returned by a Storer when the captcha does not exist.
+var ErrCaptchaNotFound = errors.New("captcha not found")
+
+// Taker is implemented by Storers that can atomically get and delete, or
+// otherwise consume, a captcha. Manager.Consume uses it, when available, to guarantee that a
+// captcha is verified only once.
+type Taker interface {
+	Take(id uint32) (*Captcha, error)
+}
+
+// AttemptCounter is implemented by Storers that can atomically increment
+// Captcha.Attempts. Without it, concurrent wrong answers may be undercounted.
+type AttemptCounter interface {
+	// IncrAttempts increments the attempts of the captcha under id, and
+	// returns the new count.
+	IncrAttempts(id uint32) (int, error)
+}
+
+// OpenStore returns the Storer for storeURL. Supported schemes are:
+//   - memory:// the in-process DefaultStore.
+//   - redis:// and rediss:// see OpenRedisStore.
+//   - sqlite3://path/to/file.db (or sqlite3://:memory:), needs a build with
+//     cgo and without the nosqlite build tag.
+//   - postgres:// and postgresql:// see https://pkg.go.dev/github.com/lib/pq.
+func OpenStore(storeURL string) (Storer, error) {
+	var scheme, rest string
+	if i := strings.Index(storeURL, "://"); i >= 0 {
+		scheme, rest = storeURL[:i], storeURL[i+3:]
+	}
+	switch scheme {
+	case "", "mem", "memory":
+		return DefaultStore, nil
+	case "redis", "rediss":
+		return OpenRedisStore(storeURL)
+	case "sqlite3", "sqlite":
+		return OpenSQLStore("sqlite3", rest)
+	case "postgres", "postgresql":
+		return OpenSQLStore("postgres", storeURL)
+	default:
+		return nil, fmt.Errorf("unknown store scheme %q", scheme)
+	}
+}
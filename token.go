@@ -0,0 +1,339 @@
+package gotcha
+
+import (
+	"container/heap"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrInvalidToken the token is malformed or its signature does not verify
+	// with any of the active keys.
+	ErrInvalidToken = errors.New("invalid token")
+	// ErrTokenExpired the token is past its expiry.
+	ErrTokenExpired = errors.New("token expired")
+	// ErrTokenUsed the token has already been passed, or was replaced.
+	ErrTokenUsed = errors.New("token already used")
+)
+
+// TokenKey is a key used to sign and verify stateless captcha tokens.
+type TokenKey struct {
+	// ID identifies the key within the token, must be unique among the
+	// active keys.
+	ID string
+	// Secret HMAC-SHA256 secret, should be at least 32 random bytes.
+	Secret []byte
+}
+
+// tokenPayload is the signed content of a stateless token. Answers are not
+// stored in the clear, but as keyed hashes salted with Salt.
+type tokenPayload struct {
+	KeyID    string    `json:"k"`
+	ID       uint32    `json:"i"`
+	Lang     string    `json:"l,omitempty"`
+	ClientID string    `json:"c,omitempty"`
+	Source   Source    `json:"src,omitempty"`
+	Media    MediaMode `json:"m,omitempty"`
+	Expiry   int64     `json:"e"`
+	Salt     []byte    `json:"s"`
+	Answers  [][]byte  `json:"a"`
+}
+
+// tokenSigner signs tokens with keys[0] and verifies them with any of keys.
+type tokenSigner struct {
+	keys   []TokenKey
+	replay ReplayStore
+}
+
+func newTokenSigner(keys ...TokenKey) *tokenSigner {
+	return &tokenSigner{
+		keys:   keys,
+		replay: newReplayCache(defaultReplayCacheSize),
+	}
+}
+
+func (ts *tokenSigner) key(id string) *TokenKey {
+	for i := range ts.keys {
+		if ts.keys[i].ID == id {
+			return &ts.keys[i]
+		}
+	}
+	return nil
+}
+
+func hashAnswer(secret, salt []byte, ans string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(salt)
+	mac.Write([]byte(ans))
+	return mac.Sum(nil)
+}
+
+// sign returns a token for c, accepting answers (normalized as they will be
+// checked) instead of c.Answers.
+func (ts *tokenSigner) sign(c *Captcha, answers []string) (string, error) {
+	key := ts.keys[0]
+	p := &tokenPayload{
+		KeyID:    key.ID,
+		ID:       c.ID,
+		Lang:     c.Lang,
+		ClientID: c.ClientID,
+		Source:   c.Source,
+		Media:    mediaMode(c),
+		Expiry:   c.Expiry.Unix(),
+		Salt:     make([]byte, 16),
+	}
+	if _, err := rand.Read(p.Salt); err != nil {
+		return "", err
+	}
+	for _, a := range answers {
+		p.Answers = append(p.Answers, hashAnswer(key.Secret, p.Salt, a))
+	}
+	b, err := json.Marshal(p)
+	if err != nil {
+		return "", err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(b)
+	mac := hmac.New(sha256.New, key.Secret)
+	mac.Write([]byte(payload))
+	return payload + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil)), nil
+}
+
+// verify checks the signature and expiry of token and returns its payload.
+// The payload of an expired token is returned along with ErrTokenExpired.
+func (ts *tokenSigner) verify(token string) (*tokenPayload, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return nil, ErrInvalidToken
+	}
+	b, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	var p tokenPayload
+	if err := json.Unmarshal(b, &p); err != nil {
+		return nil, ErrInvalidToken
+	}
+	key := ts.key(p.KeyID)
+	if key == nil {
+		return nil, ErrInvalidToken
+	}
+	mac := hmac.New(sha256.New, key.Secret)
+	mac.Write([]byte(parts[0]))
+	if !hmac.Equal(sig, mac.Sum(nil)) {
+		return nil, ErrInvalidToken
+	}
+	if time.Now().After(time.Unix(p.Expiry, 0)) {
+		return &p, ErrTokenExpired
+	}
+	return &p, nil
+}
+
+// match reports whether ans is one of the acceptable answers in p.
+func (ts *tokenSigner) match(p *tokenPayload, ans string) bool {
+	key := ts.key(p.KeyID)
+	if key == nil {
+		return false
+	}
+	h := hashAnswer(key.Secret, p.Salt, ans)
+	for _, a := range p.Answers {
+		if hmac.Equal(a, h) {
+			return true
+		}
+	}
+	return false
+}
+
+// check verifies token and ans, and marks token as passed on hostname if it
+// passes. The passed token can be consumed within lifetime. If maxAttempts is
+// positive, the token is invalidated after maxAttempts wrong answers.
+func (ts *tokenSigner) check(token, ans, hostname string, lifetime time.Duration, maxAttempts int) error {
+	p, err := ts.verify(token)
+	if err != nil {
+		return err
+	}
+	expiry := time.Unix(p.Expiry, 0)
+	var n int
+	if maxAttempts > 0 {
+		// reserve the attempt before matching, so that concurrent
+		// guesses cannot get past maxAttempts
+		if n, err = ts.replay.Attempt(token, expiry); err != nil {
+			return err
+		}
+		if n > maxAttempts {
+			return ErrTooManyAttempts
+		}
+	}
+	if !ts.match(p, ans) {
+		if maxAttempts > 0 && n >= maxAttempts {
+			return ErrTooManyAttempts
+		}
+		return ErrWrongAnswer
+	}
+	return ts.replay.Pass(token, expiry, hostname, lifetime)
+}
+
+// ReplayStore records the attempts on stateless tokens, and their passing
+// and consuming, until they expire, so that none is passed or consumed twice.
+// The default one only knows the tokens of its own process: gotcha instances
+// behind a load balancer share one, as a RedisReplayStore, see
+// WithReplayStore.
+type ReplayStore interface {
+	// Attempt records an attempt on token, and returns the attempt count.
+	Attempt(token string, expiry time.Time) (int, error)
+	// Pass records token as passed on hostname, it can be consumed within
+	// lifetime. Returns ErrTokenUsed if token was already passed, or spent.
+	Pass(token string, expiry time.Time, hostname string, lifetime time.Duration) error
+	// Consume marks the passed token as consumed, and returns when and on
+	// which hostname it was passed. Returns ErrNotPassed if token was never
+	// passed (or its lifetime is over), and ErrTokenUsed if it was already
+	// consumed.
+	Consume(token string) (passedAt time.Time, hostname string, err error)
+	// Spend records token as used without passing it, so that it no longer
+	// can be. Refreshing a captcha spends the token it replaces.
+	Spend(token string, expiry time.Time) error
+}
+
+const defaultReplayCacheSize = 1 << 16
+
+// replayCache in-memory ReplayStore of up to size tokens. Once full, it
+// evicts the token closest to expiry, which can then be replayed until it
+// expires.
+type replayCache struct {
+	sync.Mutex
+	size int
+	seen map[string]*replayEntry
+	// byExpiry heap of the entries of seen, the closest to expiry first.
+	byExpiry replayHeap
+}
+
+type replayEntry struct {
+	token    string
+	index    int
+	expiry   time.Time
+	attempts int
+	passedAt time.Time
+	validTil time.Time
+	hostname string
+	consumed bool
+	spent    bool
+}
+
+func newReplayCache(size int) *replayCache {
+	return &replayCache{size: size, seen: make(map[string]*replayEntry)}
+}
+
+// entry returns the entry for token, creating it if needed.
+func (rc *replayCache) entry(token string, expiry time.Time) *replayEntry {
+	if e, ok := rc.seen[token]; ok {
+		return e
+	}
+	if len(rc.seen) >= rc.size {
+		rc.gc()
+		if len(rc.seen) >= rc.size {
+			e := heap.Pop(&rc.byExpiry).(*replayEntry)
+			delete(rc.seen, e.token)
+		}
+	}
+	e := &replayEntry{token: token, expiry: expiry}
+	rc.seen[token] = e
+	heap.Push(&rc.byExpiry, e)
+	return e
+}
+
+func (rc *replayCache) Attempt(token string, expiry time.Time) (int, error) {
+	rc.Lock()
+	defer rc.Unlock()
+	e := rc.entry(token, expiry)
+	e.attempts++
+	return e.attempts, nil
+}
+
+func (rc *replayCache) Pass(token string, expiry time.Time, hostname string, lifetime time.Duration) error {
+	rc.Lock()
+	defer rc.Unlock()
+	e := rc.entry(token, expiry)
+	if !e.passedAt.IsZero() || e.spent {
+		return ErrTokenUsed
+	}
+	now := time.Now()
+	e.passedAt = now
+	e.validTil = now.Add(lifetime)
+	e.hostname = hostname
+	// keep it around for as long as the token can be replayed
+	if e.validTil.After(e.expiry) {
+		e.expiry = e.validTil
+		heap.Fix(&rc.byExpiry, e.index)
+	}
+	return nil
+}
+
+func (rc *replayCache) Consume(token string) (time.Time, string, error) {
+	rc.Lock()
+	defer rc.Unlock()
+	e, ok := rc.seen[token]
+	if !ok || e.passedAt.IsZero() || time.Now().After(e.validTil) {
+		return time.Time{}, "", ErrNotPassed
+	}
+	if e.consumed {
+		return time.Time{}, "", ErrTokenUsed
+	}
+	e.consumed = true
+	return e.passedAt, e.hostname, nil
+}
+
+func (rc *replayCache) Spend(token string, expiry time.Time) error {
+	rc.Lock()
+	defer rc.Unlock()
+	rc.entry(token, expiry).spent = true
+	return nil
+}
+
+func (rc *replayCache) GC() {
+	rc.Lock()
+	defer rc.Unlock()
+	rc.gc()
+}
+
+func (rc *replayCache) gc() {
+	now := time.Now()
+	for len(rc.byExpiry) > 0 && now.After(rc.byExpiry[0].expiry) {
+		e := heap.Pop(&rc.byExpiry).(*replayEntry)
+		delete(rc.seen, e.token)
+	}
+}
+
+// replayHeap container/heap of replay entries by expiry.
+type replayHeap []*replayEntry
+
+func (h replayHeap) Len() int           { return len(h) }
+func (h replayHeap) Less(i, j int) bool { return h[i].expiry.Before(h[j].expiry) }
+func (h replayHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *replayHeap) Push(x interface{}) {
+	e := x.(*replayEntry)
+	e.index = len(*h)
+	*h = append(*h, e)
+}
+
+func (h *replayHeap) Pop() interface{} {
+	old := *h
+	e := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return e
+}
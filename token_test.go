@@ -0,0 +1,209 @@
+package gotcha
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTokenSigner(t *testing.T) {
+	old := TokenKey{ID: "old", Secret: []byte("old-secret-old-secret-old-secret")}
+	cur := TokenKey{ID: "cur", Secret: []byte("cur-secret-cur-secret-cur-secret")}
+	c := &Captcha{
+		ID:      7,
+		Lang:    "es",
+		Answers: []string{"4", "cuatro"},
+		Expiry:  time.Now().Add(time.Minute),
+	}
+
+	t.Run("check", func(t *testing.T) {
+		ts := newTokenSigner(cur)
+		token, err := ts.sign(c, c.Answers)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(token, "cuatro") {
+			t.Fatal("token leaks the answer")
+		}
+		p, err := ts.verify(token)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.ID != c.ID || p.Lang != c.Lang {
+			t.Errorf("expected id %d lang %q got %d %q", c.ID, c.Lang, p.ID, p.Lang)
+		}
+		if err := ts.check(token, "5", "example.com", time.Minute, 0); !errors.Is(err, ErrWrongAnswer) {
+			t.Errorf("expected %v got %v", ErrWrongAnswer, err)
+		}
+		if err := ts.check(token, "cuatro", "example.com", time.Minute, 0); err != nil {
+			t.Errorf("expected pass got %v", err)
+		}
+		if err := ts.check(token, "cuatro", "example.com", time.Minute, 0); !errors.Is(err, ErrTokenUsed) {
+			t.Errorf("expected %v got %v", ErrTokenUsed, err)
+		}
+	})
+
+	t.Run("attempts", func(t *testing.T) {
+		ts := newTokenSigner(cur)
+		token, _ := ts.sign(c, c.Answers)
+		for i := 0; i < 2; i++ {
+			if err := ts.check(token, "5", "example.com", time.Minute, 3); !errors.Is(err, ErrWrongAnswer) {
+				t.Errorf("expected %v got %v", ErrWrongAnswer, err)
+			}
+		}
+		if err := ts.check(token, "5", "example.com", time.Minute, 3); !errors.Is(err, ErrTooManyAttempts) {
+			t.Errorf("expected %v got %v", ErrTooManyAttempts, err)
+		}
+		if err := ts.check(token, "4", "example.com", time.Minute, 3); !errors.Is(err, ErrTooManyAttempts) {
+			t.Errorf("expected right answer to be rejected after max attempts, got %v", err)
+		}
+	})
+
+	t.Run("parallel attempts", func(t *testing.T) {
+		ts := newTokenSigner(cur)
+		token, _ := ts.sign(c, c.Answers)
+		var wg sync.WaitGroup
+		var wrong int32
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if err := ts.check(token, "5", "example.com", time.Minute, 3); errors.Is(err, ErrWrongAnswer) {
+					atomic.AddInt32(&wrong, 1)
+				}
+			}()
+		}
+		wg.Wait()
+		if wrong != 2 {
+			t.Errorf("expected 2 wrong answers before the token is exhausted, got %d", wrong)
+		}
+	})
+
+	t.Run("full cache", func(t *testing.T) {
+		ts := newTokenSigner(cur)
+		ts.replay = newReplayCache(2)
+		var tokens []string
+		for i, d := range []time.Duration{2 * time.Minute, time.Minute, 3 * time.Minute} {
+			token, _ := ts.sign(&Captcha{ID: uint32(i), Expiry: time.Now().Add(d)}, c.Answers)
+			if err := ts.check(token, "4", "example.com", time.Second, 0); err != nil {
+				t.Fatalf("token %d: %v", i, err)
+			}
+			tokens = append(tokens, token)
+		}
+		// the token closest to expiry was evicted for the last one
+		for i, want := range []error{ErrTokenUsed, nil, ErrTokenUsed} {
+			if err := ts.check(tokens[i], "4", "example.com", time.Second, 0); !errors.Is(err, want) {
+				t.Errorf("token %d: expected %v got %v", i, want, err)
+			}
+		}
+	})
+
+	t.Run("rotation", func(t *testing.T) {
+		token, err := newTokenSigner(old).sign(c, c.Answers)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := newTokenSigner(cur, old).check(token, "4", "example.com", time.Minute, 0); err != nil {
+			t.Errorf("expected token signed with a retired key to verify, got %v", err)
+		}
+		if _, err := newTokenSigner(cur).verify(token); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("expected %v got %v", ErrInvalidToken, err)
+		}
+	})
+
+	t.Run("tampered", func(t *testing.T) {
+		ts := newTokenSigner(cur)
+		token, _ := ts.sign(c, c.Answers)
+		forged, _ := newTokenSigner(TokenKey{ID: "cur", Secret: []byte("guess")}).sign(c, c.Answers)
+		parts := strings.Split(token, ".")
+		for _, tok := range []string{
+			forged,
+			parts[0],
+			parts[0] + "." + strings.Split(forged, ".")[1],
+			"x" + token,
+		} {
+			if _, err := ts.verify(tok); !errors.Is(err, ErrInvalidToken) {
+				t.Errorf("expected %v for %q got %v", ErrInvalidToken, tok, err)
+			}
+		}
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		ts := newTokenSigner(cur)
+		exp := *c
+		exp.Expiry = time.Now().Add(-time.Second)
+		token, _ := ts.sign(&exp, exp.Answers)
+		if _, err := ts.verify(token); !errors.Is(err, ErrTokenExpired) {
+			t.Errorf("expected %v got %v", ErrTokenExpired, err)
+		}
+	})
+}
+
+func TestReplayCache(t *testing.T) {
+	testReplayStore(t, newReplayCache(defaultReplayCacheSize))
+}
+
+// testReplayStore tests rs with tokens unique to each call.
+func testReplayStore(t *testing.T, rs ReplayStore) {
+	t.Helper()
+	expiry := time.Now().Add(time.Minute)
+	token := func(name string) string {
+		return t.Name() + "/" + name
+	}
+
+	for i := 1; i <= 3; i++ {
+		n, err := rs.Attempt(token("attempts"), expiry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != i {
+			t.Errorf("expected %d attempts got %d", i, n)
+		}
+	}
+
+	if _, _, err := rs.Consume(token("passed")); !errors.Is(err, ErrNotPassed) {
+		t.Errorf("expected %v got %v", ErrNotPassed, err)
+	}
+	if err := rs.Pass(token("passed"), expiry, "example.com", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if err := rs.Pass(token("passed"), expiry, "example.com", time.Minute); !errors.Is(err, ErrTokenUsed) {
+		t.Errorf("expected %v got %v", ErrTokenUsed, err)
+	}
+	// spending a passed token leaves it to be consumed
+	if err := rs.Spend(token("passed"), expiry); err != nil {
+		t.Fatal(err)
+	}
+	passedAt, hostname, err := rs.Consume(token("passed"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if passedAt.IsZero() || hostname != "example.com" {
+		t.Errorf("unexpected pass at %v on %q", passedAt, hostname)
+	}
+	if _, _, err := rs.Consume(token("passed")); !errors.Is(err, ErrTokenUsed) {
+		t.Errorf("expected %v got %v", ErrTokenUsed, err)
+	}
+
+	if err := rs.Spend(token("spent"), expiry); err != nil {
+		t.Fatal(err)
+	}
+	if err := rs.Pass(token("spent"), expiry, "example.com", time.Minute); !errors.Is(err, ErrTokenUsed) {
+		t.Errorf("expected %v got %v", ErrTokenUsed, err)
+	}
+	if _, _, err := rs.Consume(token("spent")); !errors.Is(err, ErrNotPassed) {
+		t.Errorf("expected %v got %v", ErrNotPassed, err)
+	}
+
+	// past its lifetime, a passed token is not anymore
+	if err := rs.Pass(token("lifetime"), expiry, "example.com", time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if _, _, err := rs.Consume(token("lifetime")); !errors.Is(err, ErrNotPassed) {
+		t.Errorf("expected %v got %v", ErrNotPassed, err)
+	}
+}
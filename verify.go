@@ -0,0 +1,206 @@
+package gotcha
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Verification error codes, returned in VerifyResponse.ErrorCodes.
+const (
+	VerifyMissingSecret   = "missing-input-secret"
+	VerifyInvalidSecret   = "invalid-input-secret"
+	VerifyMissingResponse = "missing-input-response"
+	VerifyInvalidResponse = "invalid-input-response"
+	// VerifyTimeoutOrDuplicate the captcha was not passed, it expired, or it
+	// has already been verified.
+	VerifyTimeoutOrDuplicate = "timeout-or-duplicate"
+)
+
+// ErrNotPassed the captcha exists but has not been passed.
+var ErrNotPassed = errors.New("captcha not passed")
+
+// VerifyResponse is the result of a server-side verification.
+type VerifyResponse struct {
+	Success bool `json:"success"`
+	// Timestamp when the captcha was passed.
+	Timestamp time.Time `json:"challenge_ts,omitempty"`
+	// Hostname of the site where the captcha was passed.
+	Hostname string `json:"hostname,omitempty"`
+	// Lang language of the challenge.
+	Lang       string   `json:"language,omitempty"`
+	ErrorCodes []string `json:"error-codes,omitempty"`
+}
+
+func (v *VerifyResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
+// Consume returns the passed captcha identified by response (its ID, or its
+// token in stateless mode) and invalidates it, so that it can only be
+// consumed once.
+func (m *Manager) Consume(response string) (*Captcha, error) {
+	if m.tokens != nil {
+		p, err := m.tokens.verify(response)
+		if err != nil && !errors.Is(err, ErrTokenExpired) {
+			return nil, err
+		}
+		passedAt, hostname, err := m.tokens.replay.Consume(response)
+		if err != nil {
+			return nil, err
+		}
+		return &Captcha{
+			ID:       p.ID,
+			Token:    response,
+			Lang:     p.Lang,
+			ClientID: p.ClientID,
+			Passed:   true,
+			PassedAt: passedAt,
+			Hostname: hostname,
+		}, nil
+	}
+
+	id, err := strconv.ParseUint(response, 10, 32)
+	if err != nil {
+		return nil, ErrCaptchaNotFound
+	}
+	var c *Captcha
+	if t, ok := m.Store.(Taker); ok {
+		c, err = t.Take(uint32(id))
+	} else {
+		c, err = m.Store.Get(uint32(id))
+		if err == nil {
+			err = m.Store.Delete(uint32(id))
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !c.Passed || time.Now().After(c.Expiry) {
+		return nil, ErrNotPassed
+	}
+	return c, nil
+}
+
+// peek returns the captcha identified by response without consuming it.
+func (m *Manager) peek(response string) (*Captcha, error) {
+	if m.tokens != nil {
+		p, err := m.tokens.verify(response)
+		if err != nil && !errors.Is(err, ErrTokenExpired) {
+			return nil, err
+		}
+		return &Captcha{ID: p.ID, Lang: p.Lang, ClientID: p.ClientID}, nil
+	}
+	id, err := strconv.ParseUint(response, 10, 32)
+	if err != nil {
+		return nil, ErrCaptchaNotFound
+	}
+	return m.Store.Get(uint32(id))
+}
+
+// managerSecret reports whether secret is the manager secret, allowed to
+// verify any captcha.
+func (m *Manager) managerSecret(secret string) bool {
+	return m.secret != "" && subtle.ConstantTimeCompare([]byte(secret), []byte(m.secret)) == 1
+}
+
+// clientSecret reports whether secret is the secret of clientID, allowed to
+// verify the captchas bound to it.
+func (m *Manager) clientSecret(clientID, secret string) bool {
+	if clientID == "" || m.Clients == nil {
+		return false
+	}
+	client, err := m.Clients.GetClient(clientID)
+	if err != nil || client.SecretKey == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(secret), []byte(client.SecretKey)) == 1
+}
+
+// siteVerify verifies response on behalf of the holder of secret.
+func (m *Manager) siteVerify(secret, response string) *VerifyResponse {
+	switch {
+	case secret == "":
+		return &VerifyResponse{ErrorCodes: []string{VerifyMissingSecret}}
+	case response == "":
+		return &VerifyResponse{ErrorCodes: []string{VerifyMissingResponse}}
+	}
+	c, err := m.peek(response)
+	if !m.managerSecret(secret) && (err != nil || !m.clientSecret(c.ClientID, secret)) {
+		// a client secret is checked against the client of the captcha, an
+		// unknown captcha is answered as a wrong secret, so that it does not
+		// tell which captchas exist
+		return &VerifyResponse{ErrorCodes: []string{VerifyInvalidSecret}}
+	}
+	if err == nil {
+		c, err = m.Consume(response)
+	}
+	switch {
+	case errors.Is(err, ErrNotPassed), errors.Is(err, ErrTokenUsed):
+		return &VerifyResponse{ErrorCodes: []string{VerifyTimeoutOrDuplicate}}
+	case errors.Is(err, ErrCaptchaNotFound):
+		// already consumed, or expired and garbage collected
+		return &VerifyResponse{ErrorCodes: []string{VerifyTimeoutOrDuplicate}}
+	case err != nil:
+		return &VerifyResponse{ErrorCodes: []string{VerifyInvalidResponse}}
+	}
+	return &VerifyResponse{
+		Success:   true,
+		Timestamp: c.PassedAt,
+		Hostname:  c.Hostname,
+		Lang:      c.Lang,
+	}
+}
+
+// requestHostname returns the hostname of the site the request was made
+// from, falling back to the requested host.
+func requestHostname(r *http.Request) string {
+	for _, h := range []string{r.Header.Get("Origin"), r.Header.Get("Referer")} {
+		if u, err := url.Parse(h); err == nil && u.Hostname() != "" {
+			return u.Hostname()
+		}
+	}
+	if host, _, err := net.SplitHostPort(r.Host); err == nil {
+		return host
+	}
+	return r.Host
+}
+
+// Verify asks the gotcha server at verifyURL (e.g.
+// https://example.com/gotcha/siteverify) whether response, the captcha ID or
+// token submitted along a form, belongs to a passed captcha. A captcha can
+// only be verified once.
+func Verify(ctx context.Context, verifyURL, secret, response string) (*VerifyResponse, error) {
+	form := url.Values{"secret": {secret}, "response": {response}}
+	req, err := http.NewRequestWithContext(
+		ctx,
+		http.MethodPost,
+		verifyURL,
+		strings.NewReader(form.Encode()),
+	)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("gotcha: verify: unexpected status %s", res.Status)
+	}
+	var v VerifyResponse
+	if err := json.NewDecoder(res.Body).Decode(&v); err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
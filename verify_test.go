@@ -0,0 +1,90 @@
+package gotcha
+
+import (
+	"context"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestVerify(t *testing.T) {
+	store := &defaultStore{captchas: make(map[uint32]*Captcha)}
+	m := &Manager{
+		Store:               store,
+		secret:              "s3cr3t",
+		mountpoint:          "/gotcha",
+		lifetimeAfterPassed: time.Minute,
+	}
+	srv := httptest.NewServer(m.Router(context.Background()))
+	defer srv.Close()
+	verifyURL := srv.URL + "/gotcha/siteverify"
+
+	passedAt := time.Now()
+	store.Create(&Captcha{
+		ID:       1,
+		Lang:     "fr",
+		Passed:   true,
+		PassedAt: passedAt,
+		Hostname: "example.com",
+		Expiry:   time.Now().Add(time.Minute),
+	})
+	store.Create(&Captcha{ID: 2, Expiry: time.Now().Add(time.Minute)})
+
+	for _, tt := range []struct {
+		name     string
+		secret   string
+		response string
+		success  bool
+		code     string
+	}{
+		{"missing secret", "", "1", false, VerifyMissingSecret},
+		{"invalid secret", "guess", "1", false, VerifyInvalidSecret},
+		{"invalid secret, unknown captcha", "guess", "3", false, VerifyInvalidSecret},
+		{"missing response", "s3cr3t", "", false, VerifyMissingResponse},
+		{"not passed", "s3cr3t", "2", false, VerifyTimeoutOrDuplicate},
+		{"passed", "s3cr3t", "1", true, ""},
+		{"duplicate", "s3cr3t", "1", false, VerifyTimeoutOrDuplicate},
+		{"unknown", "s3cr3t", strconv.Itoa(3), false, VerifyTimeoutOrDuplicate},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := Verify(context.Background(), verifyURL, tt.secret, tt.response)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.Success != tt.success {
+				t.Fatalf("expected success %v got %+v", tt.success, res)
+			}
+			if tt.code != "" && (len(res.ErrorCodes) != 1 || res.ErrorCodes[0] != tt.code) {
+				t.Errorf("expected error code %q got %v", tt.code, res.ErrorCodes)
+			}
+			if tt.success {
+				if res.Hostname != "example.com" || res.Lang != "fr" || !res.Timestamp.Equal(passedAt) {
+					t.Errorf("unexpected response %+v", res)
+				}
+			}
+		})
+	}
+}
+
+func TestConsumeExpiredToken(t *testing.T) {
+	m := &Manager{
+		tokens: newTokenSigner(TokenKey{ID: "k", Secret: []byte("k-secret-k-secret-k-secret-k-sec")}),
+	}
+	c := &Captcha{ID: 9, Lang: "en", Answers: []string{"4"}, Expiry: time.Now().Add(-time.Minute)}
+	token, err := m.tokens.sign(c, c.Answers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// passed just before it expired
+	if err := m.tokens.replay.Pass(token, c.Expiry, "example.com", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	got, err := m.Consume(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != c.ID || !got.Passed || got.Hostname != "example.com" {
+		t.Errorf("unexpected captcha %+v", got)
+	}
+}